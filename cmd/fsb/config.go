@@ -0,0 +1,67 @@
+package main
+
+import (
+	"EverythingSuckz/fsb/config"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Check or print the configuration.",
+	Long: `Check or print the configuration read from the flags, the environment,
+fsb.env and the config file, in that order of precedence.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+var configCheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Validate the configuration and report every error.",
+	Args:  cobra.NoArgs,
+	RunE:  checkConfig,
+}
+
+var configPrintCmd = &cobra.Command{
+	Use:   "print",
+	Short: "Print the effective configuration as YAML.",
+	Args:  cobra.NoArgs,
+	RunE:  printConfig,
+}
+
+func init() {
+	configPrintCmd.Flags().Bool("redacted", false, "Mask tokens, secrets and proxy passwords")
+	for _, cmd := range []*cobra.Command{configCheckCmd, configPrintCmd} {
+		config.SetFlagsFromConfig(cmd)
+		// main prints the returned error
+		cmd.SilenceUsage = true
+		cmd.SilenceErrors = true
+		configCmd.AddCommand(cmd)
+	}
+}
+
+func checkConfig(cmd *cobra.Command, args []string) error {
+	if err := config.Check(zap.NewNop(), cmd); err != nil {
+		return fmt.Errorf("invalid config:\n%w", err)
+	}
+	fmt.Println("Config is valid")
+	return nil
+}
+
+func printConfig(cmd *cobra.Command, args []string) error {
+	// The config is printed even if it is invalid, check reports why
+	err := config.Check(zap.NewNop(), cmd)
+	redacted, _ := cmd.Flags().GetBool("redacted")
+	out, marshalErr := config.Marshal(redacted)
+	if marshalErr != nil {
+		return marshalErr
+	}
+	fmt.Print(string(out))
+	if err != nil {
+		return fmt.Errorf("invalid config:\n%w", err)
+	}
+	return nil
+}
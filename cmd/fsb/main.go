@@ -26,6 +26,8 @@ func init() {
 	config.SetFlagsFromConfig(runCmd)
 	rootCmd.AddCommand(runCmd)
 	rootCmd.AddCommand(sessionCmd)
+	rootCmd.AddCommand(sessionsCmd)
+	rootCmd.AddCommand(configCmd)
 	rootCmd.SetVersionTemplate(fmt.Sprintf(`Telegram File Stream Bot version %s`, versionString))
 }
 
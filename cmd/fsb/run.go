@@ -4,11 +4,20 @@ import (
 	"EverythingSuckz/fsb/config"
 	"EverythingSuckz/fsb/internal/bot"
 	"EverythingSuckz/fsb/internal/cache"
+	"EverythingSuckz/fsb/internal/links"
 	"EverythingSuckz/fsb/internal/routes"
+	"EverythingSuckz/fsb/internal/tracing"
 	"EverythingSuckz/fsb/internal/types"
 	"EverythingSuckz/fsb/internal/utils"
+	"context"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -32,6 +41,20 @@ func runApp(cmd *cobra.Command, args []string) {
 	mainLogger := log.Named("Main")
 	mainLogger.Info("Starting server")
 	config.Load(log, cmd)
+	logOptions := utils.LogOptions{
+		Level:      config.ValueOf.LogLevel,
+		Format:     config.ValueOf.LogFormat,
+		TimeFormat: config.ValueOf.LogTimeFormat,
+		File:       config.ValueOf.LogFile,
+		MaxSize:    config.ValueOf.LogMaxSize,
+		MaxBackups: config.ValueOf.LogMaxBackups,
+		MaxAge:     config.ValueOf.LogMaxAge,
+	}
+	err := utils.ConfigureLogger(logOptions)
+	if err != nil {
+		mainLogger.Error("Invalid log config, logging to stdout only", zap.Error(err))
+	}
+	utils.InitAccessLogger(config.ValueOf.AccessLogFile, logOptions)
 	
 	// 设置全局 HTTP 客户端的代理
 	if config.ValueOf.Dev {
@@ -40,6 +63,9 @@ func runApp(cmd *cobra.Command, args []string) {
 		utils.SetupProxy(http.DefaultClient)
 	}
 	
+	if err := tracing.Init(log); err != nil {
+		mainLogger.Error("Failed to set up tracing", zap.Error(err))
+	}
 	router := getRouter(log)
 
 	mainBot, err := bot.StartClient(log)
@@ -49,6 +75,9 @@ func runApp(cmd *cobra.Command, args []string) {
 		mainLogger.Warn("⚠️  但HTTP服务器将继续启动，您可以测试其他API功能")
 	} else {
 		cache.InitCache(log)
+		if err := links.Init(log, config.ValueOf.LinksDB); err != nil {
+			mainLogger.Error("Failed to open links database, /rename and /expire won't work", zap.Error(err))
+		}
 		workers, err := bot.StartWorkers(log)
 		if err != nil {
 			mainLogger.Error("Failed to start workers", zap.Error(err))
@@ -57,20 +86,119 @@ func runApp(cmd *cobra.Command, args []string) {
 			workers.AddDefaultClient(mainBot, mainBot.Self)
 		}
 
+		bot.StartHealthMonitor(log)
+
 		// 初始化上传worker管理器
 		bot.InitUploadWorkerManager(log, config.ValueOf.APICooldownSeconds)
 
 		bot.StartUserBot(log)
+		reloadWorkersOnSignal(mainLogger)
 		mainLogger.Info("✅ Telegram客户端已连接")
 	}
 
 	mainLogger.Info("Server started", zap.Int("port", config.ValueOf.Port))
 	mainLogger.Info("File Stream Bot", zap.String("version", versionString))
 	mainLogger.Sugar().Infof("Server is running at %s", config.ValueOf.Host)
-	err = router.Run(fmt.Sprintf(":%d", config.ValueOf.Port))
-	if err != nil {
-		mainLogger.Sugar().Fatalln(err)
+	// 请求的context在关闭超时后取消，中断还在进行的流媒体和上传
+	requestCtx, cancelRequests := context.WithCancel(context.Background())
+	handlers := &handlerGroup{}
+	server := &http.Server{
+		Addr:        fmt.Sprintf(":%d", config.ValueOf.Port),
+		Handler:     handlers.wrap(router),
+		BaseContext: func(net.Listener) context.Context { return requestCtx },
+	}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			mainLogger.Sugar().Fatalln(err)
+		}
+	}()
+
+	signalCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	<-signalCtx.Done()
+	stop()
+	shutdown(mainLogger, server, handlers, cancelRequests)
+}
+
+// handlerStopTimeout bounds the wait for cancelled handlers to return
+const handlerStopTimeout = 10 * time.Second
+
+// handlerGroup tracks the running HTTP handlers. server.Close doesn't wait
+// for them, but the Telegram clients they use must outlive them.
+type handlerGroup struct {
+	sync.WaitGroup
+}
+
+func (g *handlerGroup) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g.Add(1)
+		defer g.Done()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// waitTimeout waits up to timeout for the handlers to return and reports
+// whether they did.
+func (g *handlerGroup) waitTimeout(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		g.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// shutdown stops accepting connections, waits for the running requests,
+// cancels the rest and stops the Telegram clients.
+func shutdown(log *zap.Logger, server *http.Server, handlers *handlerGroup, cancelRequests context.CancelFunc) {
+	drainServer(log, server, handlers, cancelRequests)
+	bot.Shutdown(log)
+
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFlush()
+	if err := tracing.Shutdown(flushCtx); err != nil {
+		log.Warn("Failed to flush traces", zap.Error(err))
 	}
+	log.Info("Shutdown complete")
+	utils.Logger.Sync()
+	utils.AccessLogger.Sync()
+}
+
+// drainServer stops accepting connections and waits up to SHUTDOWN_TIMEOUT
+// for the running requests. The requests still running are then cancelled
+// and their handlers given handlerStopTimeout to return.
+func drainServer(log *zap.Logger, server *http.Server, handlers *handlerGroup, cancelRequests context.CancelFunc) {
+	timeout := time.Duration(config.ValueOf.DrainTimeout) * time.Second
+	log.Info("Shutting down, waiting for running streams and uploads", zap.Duration("timeout", timeout))
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Warn("Requests still running after the shutdown timeout, cancelling them", zap.Error(err))
+		cancelRequests()
+		server.Close()
+	}
+	cancelRequests()
+	if !handlers.waitTimeout(handlerStopTimeout) {
+		log.Warn("Handlers still running after they were cancelled", zap.Duration("timeout", handlerStopTimeout))
+	}
+}
+
+// reloadWorkersOnSignal reloads the worker tokens on SIGHUP.
+func reloadWorkersOnSignal(log *zap.Logger) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			log.Info("Received SIGHUP, reloading workers")
+			if _, err := bot.ReloadWorkers(); err != nil {
+				log.Error("Failed to reload workers", zap.Error(err))
+			}
+		}
+	}()
 }
 
 func getRouter(log *zap.Logger) *gin.Engine {
@@ -80,7 +208,12 @@ func getRouter(log *zap.Logger) *gin.Engine {
 		gin.SetMode(gin.ReleaseMode)
 	}
 	router := gin.Default()
-	router.Use(gin.ErrorLogger())
+	// X-Forwarded-For is only used for the client IP if it comes from one of these
+	if err := router.SetTrustedProxies(config.ValueOf.TrustedProxies); err != nil {
+		log.Error("Invalid TRUSTED_PROXIES, no proxy is trusted", zap.Error(err))
+		router.SetTrustedProxies(nil)
+	}
+	router.Use(gin.ErrorLogger(), tracing.Gin())
 	router.GET("/", func(ctx *gin.Context) {
 		ctx.JSON(http.StatusOK, types.RootResponse{
 			Message: "Server is running.",
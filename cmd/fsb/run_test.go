@@ -0,0 +1,80 @@
+package main
+
+import (
+	"EverythingSuckz/fsb/config"
+	"context"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// A stream still running after SHUTDOWN_TIMEOUT is cancelled, and its
+// handler returns before the Telegram clients would be stopped.
+func TestDrainServerWaitsForHandlers(t *testing.T) {
+	oldTimeout := config.ValueOf.DrainTimeout
+	config.ValueOf.DrainTimeout = 1
+	defer func() { config.ValueOf.DrainTimeout = oldTimeout }()
+
+	started := make(chan struct{})
+	var returned atomic.Bool
+	requestCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+	handlers := &handlerGroup{}
+	server := &http.Server{
+		Handler: handlers.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			close(started)
+			<-r.Context().Done()
+			// the stream cleans up with its worker after being cancelled
+			time.Sleep(200 * time.Millisecond)
+			returned.Store(true)
+		})),
+		BaseContext: func(net.Listener) context.Context { return requestCtx },
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(ln)
+
+	resp, err := http.Get("http://" + ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	<-started
+
+	start := time.Now()
+	drainServer(zap.NewNop(), server, handlers, cancelRequests)
+	if !returned.Load() {
+		t.Fatal("drainServer returned before the running handler")
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("drainServer returned after %s, before SHUTDOWN_TIMEOUT", elapsed)
+	}
+}
+
+func TestDrainServerIdle(t *testing.T) {
+	oldTimeout := config.ValueOf.DrainTimeout
+	config.ValueOf.DrainTimeout = 5
+	defer func() { config.ValueOf.DrainTimeout = oldTimeout }()
+
+	handlers := &handlerGroup{}
+	server := &http.Server{Handler: handlers.wrap(http.NotFoundHandler())}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(ln)
+
+	start := time.Now()
+	drainServer(zap.NewNop(), server, handlers, func() {})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("drainServer took %s without running requests", elapsed)
+	}
+}
@@ -1,41 +1,134 @@
 package main
 
 import (
+	"bufio"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"os"
 
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/pkg/phonelogin"
 	"EverythingSuckz/fsb/pkg/qrlogin"
+	"EverythingSuckz/fsb/pkg/sessionstring"
 
 	"github.com/spf13/cobra"
 )
 
 var sessionCmd = &cobra.Command{
-	Use:                "session",
-	Short:              "Generate a string session.",
+	Use:   "session",
+	Short: "Generate a string session.",
+	Long: `Generate a pyrogram string session for USER_SESSION by scanning a QR code
+or logging in with a phone number.
+
+The phone login asks for the phone number, the login code and the 2FA
+password. Answers set in FSB_PHONE, FSB_CODE and FSB_PASSWORD are used
+without asking. With --non-interactive the remaining answers are read from
+stdin one line each, without prompts, and only the session string is
+written to stdout.
+
+--format picks the library the string session is written for: pyrogram,
+telethon, gotd or gotgproto. USER_SESSION accepts all of them.`,
+	Example: `  fsb session -I 123456 -H abcdef -T phone
+  FSB_PHONE=+14155552671 fsb session -I 123456 -H abcdef -T phone --non-interactive < code-pipe`,
 	DisableSuggestions: false,
-	Run:                generateSession,
+	SilenceUsage:       true,
+	SilenceErrors:      true,
+	RunE:               generateSession,
 }
 
 func init() {
 	sessionCmd.Flags().StringP("login-type", "T", "qr", "The login type to use. Can be either 'qr' or 'phone'")
 	sessionCmd.Flags().Int32P("api-id", "I", 0, "The API ID to use for the session (required).")
 	sessionCmd.Flags().StringP("api-hash", "H", "", "The API hash to use for the session (required).")
+	sessionCmd.Flags().Bool("non-interactive", false, "Read the phone login answers from env variables and stdin without prompts")
+	sessionCmd.Flags().StringP("format", "F", string(sessionstring.Pyrogram), "The string session format: pyrogram, telethon, gotd or gotgproto")
 	sessionCmd.MarkFlagRequired("api-id")
 	sessionCmd.MarkFlagRequired("api-hash")
+	sessionVerifyCmd.Flags().StringP("config", "c", "", "YAML or TOML config file, defaults to FSB_CONFIG or fsb.yaml, fsb.yml or fsb.toml if present")
+	sessionCmd.AddCommand(sessionVerifyCmd)
+}
+
+var sessionVerifyCmd = &cobra.Command{
+	Use:   "verify [session string | -]",
+	Short: "Check that a string session is well-formed without connecting.",
+	Long: `Decode a string session of any supported format and print its DC and user ID.
+The session is read from the argument, from stdin with "-" or from
+USER_SESSION, which is looked up in the environment, fsb.env and the config
+file like fsb run does. Nothing is sent to Telegram, so a session that was logged out
+still passes.`,
+	Args:          cobra.MaximumNArgs(1),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE:          verifySession,
 }
 
-func generateSession(cmd *cobra.Command, args []string) {
+func generateSession(cmd *cobra.Command, args []string) error {
 	loginType, _ := cmd.Flags().GetString("login-type")
 	apiId, _ := cmd.Flags().GetInt32("api-id")
 	apiHash, _ := cmd.Flags().GetString("api-hash")
+	formatName, _ := cmd.Flags().GetString("format")
+	format, err := sessionstring.ParseFormat(formatName)
+	if err != nil {
+		return err
+	}
 	if loginType == "qr" {
-		qrlogin.GenerateQRSession(int(apiId), apiHash)
+		return qrlogin.GenerateQRSession(int(apiId), apiHash, format)
 	} else if loginType == "phone" {
-		generatePhoneSession()
+		nonInteractive, _ := cmd.Flags().GetBool("non-interactive")
+		return phonelogin.GeneratePhoneSession(int(apiId), apiHash, format, !nonInteractive)
 	} else {
 		fmt.Println("Invalid login type. Please use either 'qr' or 'phone'")
 	}
+	return nil
 }
 
-func generatePhoneSession() {
-	fmt.Println("Phone session is not implemented yet.")
+func verifySession(cmd *cobra.Command, args []string) error {
+	var value string
+	switch {
+	case len(args) == 1 && args[0] == "-":
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("failed to read the session from stdin: %w", err)
+		}
+		value = line
+	case len(args) == 1:
+		value = args[0]
+	default:
+		configFile, _ := cmd.Flags().GetString("config")
+		var err error
+		value, _, err = config.LookupEnv("USER_SESSION", configFile)
+		if err != nil {
+			return err
+		}
+		if value == "" {
+			return errors.New("no session given, pass it as argument, with - on stdin or in USER_SESSION")
+		}
+	}
+	s, err := sessionstring.Decode(value)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Format:     ", s.Format)
+	fmt.Println("DC:         ", s.Data.DC)
+	if s.Data.Config.TestMode {
+		fmt.Println("Test mode:   yes")
+	}
+	if s.Data.Addr != "" {
+		fmt.Println("Address:    ", s.Data.Addr)
+	}
+	if s.Account.UserID != 0 {
+		fmt.Println("User ID:    ", s.Account.UserID)
+		fmt.Println("Bot:        ", s.Account.IsBot)
+	} else if s.Format == sessionstring.Pyrogram {
+		fmt.Println("User ID:     unknown, written by an older version of fsb")
+	} else {
+		fmt.Printf("User ID:     not stored in %s sessions\n", s.Format)
+	}
+	if s.Account.AppID != 0 {
+		fmt.Println("API ID:     ", s.Account.AppID)
+	}
+	fmt.Println("Auth key ID:", hex.EncodeToString(s.Data.AuthKeyID))
+	fmt.Println("Session is well-formed")
+	return nil
 }
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/utils"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+var sessionsCmd = &cobra.Command{
+	Use:   "sessions",
+	Short: "Manage the session files of the worker bots.",
+	Long: `Manage the session files of the worker bots in the sessions directory.
+Session files are named bot-<bot id>.session after the bot they belong to.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+var sessionsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the session files and the token they belong to.",
+	Args:  cobra.NoArgs,
+	RunE:  listSessions,
+}
+
+var sessionsPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Delete session files of bots that are no longer configured.",
+	Long: `Delete the session files of bots without a configured token. The
+worker-<index>.session files of older versions are renamed after the
+configured bot they are logged in as, or deleted if there is none. Stop the
+bot first.`,
+	Args: cobra.NoArgs,
+	RunE: pruneSessions,
+}
+
+var sessionsVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check that every session file is logged in as the bot it is named after.",
+	Args:  cobra.NoArgs,
+	RunE:  verifySessions,
+}
+
+func init() {
+	sessionsPruneCmd.Flags().Bool("dry-run", false, "Only print the files that would be deleted")
+	sessionsCmd.PersistentFlags().StringP("config", "c", "", "YAML or TOML config file, defaults to FSB_CONFIG or fsb.yaml, fsb.yml or fsb.toml if present")
+	for _, cmd := range []*cobra.Command{sessionsListCmd, sessionsPruneCmd, sessionsVerifyCmd} {
+		// main prints the returned error
+		cmd.SilenceUsage = true
+		cmd.SilenceErrors = true
+		sessionsCmd.AddCommand(cmd)
+	}
+}
+
+// sessionTokens maps the bot IDs of the configured worker tokens to their label.
+// Any error is returned, so that prune never deletes the session of a bot whose
+// token couldn't be read.
+func sessionTokens(cmd *cobra.Command) (map[int64]string, error) {
+	utils.InitLogger(false)
+	configFile, _ := cmd.Flags().GetString("config")
+	tokens, err := config.LoadTokens(utils.Logger, configFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load worker tokens: %w", err)
+	}
+	labels := make(map[int64]string, len(tokens))
+	for _, token := range tokens {
+		labels[token.BotID()] = token.Label
+	}
+	return labels, nil
+}
+
+func listSessions(cmd *cobra.Command, args []string) error {
+	labels, err := sessionTokens(cmd)
+	if err != nil {
+		return err
+	}
+	files, err := bot.ListSessions()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE\tBOT ID\tTOKEN\tSIZE\tMODIFIED")
+	for _, file := range files {
+		botID, label := "-", "-"
+		switch {
+		case file.Legacy:
+			label = "(legacy)"
+		case file.BotID == 0:
+			label = "(unknown)"
+		default:
+			botID = fmt.Sprint(file.BotID)
+			label = "(stale)"
+			if l, ok := labels[file.BotID]; ok {
+				label = l
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", file.Path, botID, label, file.Size, file.ModTime.Format("2006-01-02 15:04"))
+	}
+	return w.Flush()
+}
+
+func pruneSessions(cmd *cobra.Command, args []string) error {
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	labels, err := sessionTokens(cmd)
+	if err != nil {
+		return fmt.Errorf("%w, not pruning", err)
+	}
+	if len(labels) == 0 {
+		return errors.New("no worker tokens configured, not pruning; check MULTI_TOKEN* and the multi token txt file")
+	}
+	botIDs := make([]int64, 0, len(labels))
+	for botID := range labels {
+		botIDs = append(botIDs, botID)
+	}
+	migrations, err := bot.MigrateLegacySessions(botIDs, dryRun)
+	for _, migration := range migrations {
+		if dryRun {
+			fmt.Fprintf(cmd.OutOrStdout(), "Would move %s to %s (%s)\n", migration.From, migration.To, labels[migration.BotID])
+		} else {
+			fmt.Fprintf(cmd.OutOrStdout(), "Moved %s to %s (%s)\n", migration.From, migration.To, labels[migration.BotID])
+		}
+	}
+	if err != nil {
+		return err
+	}
+	migrated := make(map[string]bool, len(migrations))
+	for _, migration := range migrations {
+		migrated[migration.From] = true
+	}
+	files, err := bot.ListSessions()
+	if err != nil {
+		return err
+	}
+	pruned := 0
+	for _, file := range files {
+		if migrated[file.Path] {
+			continue
+		}
+		if !file.Legacy {
+			if _, ok := labels[file.BotID]; ok || file.BotID == 0 {
+				continue
+			}
+		}
+		pruned++
+		if dryRun {
+			fmt.Fprintf(cmd.OutOrStdout(), "Would delete %s\n", file.Path)
+			continue
+		}
+		if err := os.Remove(file.Path); err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Deleted %s\n", file.Path)
+	}
+	if dryRun {
+		fmt.Fprintf(cmd.OutOrStdout(), "%d of %d session files would be pruned\n", pruned, len(files))
+	} else {
+		fmt.Fprintf(cmd.OutOrStdout(), "%d of %d session files pruned\n", pruned, len(files))
+	}
+	return nil
+}
+
+func verifySessions(cmd *cobra.Command, args []string) error {
+	labels, err := sessionTokens(cmd)
+	if err != nil {
+		return err
+	}
+	files, err := bot.ListSessions()
+	if err != nil {
+		return err
+	}
+	out := cmd.OutOrStdout()
+	found := make(map[int64]bool, len(files))
+	failed := 0
+	for _, file := range files {
+		if file.BotID == 0 {
+			continue
+		}
+		found[file.BotID] = true
+		if err := bot.VerifySession(file.Path, file.BotID); err != nil {
+			failed++
+			fmt.Fprintf(out, "FAIL %s: %v\n", file.Path, err)
+			continue
+		}
+		fmt.Fprintf(out, "OK   %s\n", file.Path)
+	}
+	missing := make([]int64, 0, len(labels))
+	for botID := range labels {
+		if !found[botID] {
+			missing = append(missing, botID)
+		}
+	}
+	sort.Slice(missing, func(i, j int) bool { return missing[i] < missing[j] })
+	for _, botID := range missing {
+		fmt.Fprintf(out, "NONE %s: no session for %s, it will log in on start\n", bot.SessionPath(botID), labels[botID])
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d session files failed verification", failed)
+	}
+	return nil
+}
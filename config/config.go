@@ -2,6 +2,7 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"net"
 	"net/http"
@@ -13,8 +14,8 @@ import (
 	"strings"
 
 	"github.com/joho/godotenv"
-	"github.com/kelseyhightower/envconfig"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"go.uber.org/zap"
 )
 
@@ -38,39 +39,69 @@ func (au *allowedUsers) Decode(value string) error {
 }
 
 type config struct {
-	ApiID          int32        `envconfig:"API_ID" required:"true"`
-	ApiHash        string       `envconfig:"API_HASH" required:"true"`
-	BotToken       string       `envconfig:"BOT_TOKEN" required:"true"`
-	LogChannelID   int64        `envconfig:"LOG_CHANNEL" required:"true"`
-	Dev            bool         `envconfig:"DEV" default:"false"`
-	Port           int          `envconfig:"PORT" default:"8080"`
-	Host           string       `envconfig:"HOST" default:""`
-	HashLength     int          `envconfig:"HASH_LENGTH" default:"6"`
-	UseSessionFile bool         `envconfig:"USE_SESSION_FILE" default:"true"`
-	UserSession    string       `envconfig:"USER_SESSION"`
-	UsePublicIP    bool         `envconfig:"USE_PUBLIC_IP" default:"false"`
-	AllowedUsers   allowedUsers `envconfig:"ALLOWED_USERS"`
-	MultiTokens    []string
+	ApiID          int32         `envconfig:"API_ID" file:"api_id" required:"true" flag:"api-id" desc:"Telegram API ID"`
+	ApiHash        string        `envconfig:"API_HASH" file:"api_hash" secret:"true" required:"true" flag:"api-hash" desc:"Telegram API Hash"`
+	BotToken       string        `envconfig:"BOT_TOKEN" file:"bot_token" secret:"true" required:"true" flag:"bot-token" desc:"Telegram Bot Token"`
+	LogChannelID   int64         `envconfig:"LOG_CHANNEL" file:"log_channel" required:"true" flag:"log-channel" desc:"Telegram Log Channel ID"`
+	Dev            bool          `envconfig:"DEV" file:"dev" default:"false" flag:"dev" desc:"Enable development mode"`
+	Port           int           `envconfig:"PORT" file:"port" default:"8080" flag:"port" short:"p" desc:"Server port"`
+	Host           string        `envconfig:"HOST" file:"host" default:"" flag:"host" desc:"Server host that will be included in links"`
+	HashLength     int           `envconfig:"HASH_LENGTH" file:"hash_length" default:"6" flag:"hash-length" desc:"Hash length in links"`
+	UseSessionFile bool          `envconfig:"USE_SESSION_FILE" file:"use_session_file" default:"true" flag:"use-session-file" desc:"Use session files"`
+	UserSession    string        `envconfig:"USER_SESSION" file:"user_session" secret:"true" flag:"user-session" desc:"Pyrogram user session"`
+	UsePublicIP    bool          `envconfig:"USE_PUBLIC_IP" file:"use_public_ip" default:"false" flag:"use-public-ip" desc:"Use public IP instead of local IP"`
+	AllowedUsers   allowedUsers  `envconfig:"ALLOWED_USERS" file:"allowed_users"`
+	AlbumLinksFile bool          `envconfig:"ALBUM_LINKS_FILE" file:"album_links_file" default:"false" flag:"album-links-file" desc:"Also send album links as a playlist/text file"`
+	LinksDB        string        `envconfig:"LINKS_DB" file:"links_db" default:"fsb.links" flag:"links-db" desc:"SQLite database of the link options set with /rename and /expire"`
+	WorkerStrategy string        `envconfig:"WORKER_STRATEGY" file:"workers.strategy" default:"round-robin" flag:"worker-strategy" desc:"Worker selection for streams: round-robin, least-connections, weighted or sticky"`
+	WorkerWeights  string        `envconfig:"WORKER_WEIGHTS" file:"workers.weights" flag:"worker-weights" desc:"Worker weights for the weighted strategy (username=weight,...)"`
+	MultiTokenFile string        `envconfig:"MULTI_TOKEN_TXT_FILE" file:"workers.tokens_file" flag:"multi-token-txt-file" desc:"File with one worker bot token (or name=token) per line"`
+	AdminToken     string        `envconfig:"ADMIN_TOKEN" file:"admin_token" secret:"true" flag:"admin-token" desc:"Bearer token for the /admin endpoints and /workers/health"`
+	MetricsToken   string        `envconfig:"METRICS_TOKEN" file:"metrics_token" secret:"true" flag:"metrics-token" desc:"Bearer token required by /metrics"`
+	OtelEndpoint   string        `envconfig:"OTEL_EXPORTER_OTLP_ENDPOINT" file:"tracing.endpoint" flag:"otel-endpoint" desc:"OTLP/HTTP endpoint traces are exported to, tracing is disabled if empty"`
+	OtelService    string        `envconfig:"OTEL_SERVICE_NAME" file:"tracing.service_name" default:"fsb"`
+	AccessLogFile  string        `envconfig:"ACCESS_LOG_FILE" file:"log.access_file" default:"logs/access.log" flag:"access-log-file" desc:"File the JSON access log of stream and upload requests is written to"`
+	TrustedProxies []string      `envconfig:"TRUSTED_PROXIES" file:"trusted_proxies" flag:"trusted-proxies" desc:"IPs or CIDRs of the reverse proxies whose X-Forwarded-For header is trusted"`
+	LogLevel       string        `envconfig:"LOG_LEVEL" file:"log.level" flag:"log-level" desc:"Log level, optionally with per-component levels (info,Stream=debug,Workers=warn)"`
+	LogFormat      string        `envconfig:"LOG_FORMAT" file:"log.format" default:"console" flag:"log-format" desc:"Format of the log written to stdout: console or json"`
+	LogTimeFormat  string        `envconfig:"LOG_TIME_FORMAT" file:"log.time_format" default:"02/01/2006 03:04 PM" flag:"log-time-format" desc:"Go time layout of the console log"`
+	LogFile        string        `envconfig:"LOG_FILE" file:"log.file" default:"logs/app.log" flag:"log-file" desc:"File the JSON log is written to, set LOG_FILE empty to disable it"`
+	LogMaxSize     int           `envconfig:"LOG_MAX_SIZE" file:"log.max_size" default:"10" flag:"log-max-size" desc:"Size in MB the log file is rotated at"`
+	LogMaxBackups  int           `envconfig:"LOG_MAX_BACKUPS" file:"log.max_backups" default:"3" flag:"log-max-backups" desc:"Rotated log files to keep"`
+	LogMaxAge      int           `envconfig:"LOG_MAX_AGE" file:"log.max_age" default:"7" flag:"log-max-age" desc:"Days rotated log files are kept"`
+	ReadyWorkers   int           `envconfig:"READY_MIN_WORKERS" file:"workers.ready_min" default:"1" flag:"ready-min-workers" desc:"Healthy workers needed for /readyz to report ready"`
+	DrainTimeout   int           `envconfig:"SHUTDOWN_TIMEOUT" file:"shutdown_timeout" default:"30" flag:"shutdown-timeout" desc:"Seconds streams and uploads are given to finish on shutdown"`
+	MultiTokens    []WorkerToken `ignored:"true"`
 
 	// 上传功能配置
-	EnableUploadAPI     bool     `envconfig:"ENABLE_UPLOAD_API" default:"false"`
-	UploadAuthToken     string   `envconfig:"UPLOAD_AUTH_TOKEN"`
-	MaxFileSize        int64    `envconfig:"MAX_FILE_SIZE" default:"2147483648"` // 2GB
-	UserQuota          int64    `envconfig:"USER_QUOTA" default:"0"`  // 0 = 不限制配额
-	AllowedMimeTypes   string   `envconfig:"ALLOWED_MIME_TYPES" default:"image/jpeg,image/png,image/gif,video/mp4,video/avi,application/pdf,text/plain,application/zip"`
-	AllowedExtensions  string   `envconfig:"ALLOWED_EXTENSIONS" default:".jpg,.jpeg,.png,.gif,.mp4,.avi,.pdf,.txt,.zip"`
-	UploadsPerMinute   int      `envconfig:"UPLOADS_PER_MINUTE" default:"5"`
-	UploadsPerHour     int      `envconfig:"UPLOADS_PER_HOUR" default:"50"`
-	ConcurrentUploads   int      `envconfig:"CONCURRENT_UPLOADS_PER_USER" default:"3"`
-	APICooldownSeconds int      `envconfig:"API_COOLDOWN_SECONDS" default:"1"`
-	EnableProtection   bool     `envconfig:"ENABLE_PROTECTION_MODE" default:"true"`
-	EnableDeepScan     bool     `envconfig:"ENABLE_DEEP_SCAN" default:"false"`
+	EnableUploadAPI     bool     `envconfig:"ENABLE_UPLOAD_API" file:"upload.enabled" default:"false" flag:"enable-upload-api" desc:"Enable upload API"`
+	UploadAuthToken     string   `envconfig:"UPLOAD_AUTH_TOKEN" file:"upload.auth_token" secret:"true" flag:"upload-auth-token" desc:"Upload API authentication token"`
+	MaxFileSize        int64    `envconfig:"MAX_FILE_SIZE" file:"upload.max_file_size" default:"2097152000" flag:"max-file-size" desc:"Maximum file size for upload (bytes), at most 4000 parts of UPLOAD_PART_SIZE"` // 2000MB，Telegram的上限
+	UserQuota          int64    `envconfig:"USER_QUOTA" file:"upload.user_quota" default:"0" flag:"user-quota" desc:"User storage quota (bytes)"`  // 0 = 不限制配额
+	AllowedMimeTypes   string   `envconfig:"ALLOWED_MIME_TYPES" file:"upload.allowed_mime_types" default:"image/jpeg,image/png,image/gif,video/mp4,video/avi,application/pdf,text/plain,application/zip" flag:"allowed-mime-types" desc:"Allowed MIME types for upload"`
+	AllowedExtensions  string   `envconfig:"ALLOWED_EXTENSIONS" file:"upload.allowed_extensions" default:".jpg,.jpeg,.png,.gif,.mp4,.avi,.pdf,.txt,.zip" flag:"allowed-extensions" desc:"Allowed file extensions for upload"`
+	UploadsPerMinute   int      `envconfig:"UPLOADS_PER_MINUTE" file:"upload.per_minute" default:"5" flag:"uploads-per-minute" desc:"Uploads allowed per minute per user"`
+	UploadsPerHour     int      `envconfig:"UPLOADS_PER_HOUR" file:"upload.per_hour" default:"50" flag:"uploads-per-hour" desc:"Uploads allowed per hour per user"`
+	ConcurrentUploads   int      `envconfig:"CONCURRENT_UPLOADS_PER_USER" file:"upload.concurrent_per_user" default:"3" flag:"concurrent-uploads" desc:"Concurrent uploads per user"`
+	APICooldownSeconds int      `envconfig:"API_COOLDOWN_SECONDS" file:"upload.cooldown_seconds" default:"1" flag:"api-cooldown-seconds" desc:"API cooldown seconds"`
+	UploadQueueTimeout int      `envconfig:"UPLOAD_QUEUE_TIMEOUT" file:"upload.queue_timeout" default:"300" flag:"upload-queue-timeout" desc:"Seconds an upload waits for a free worker"` // 所有worker都不可用时上传最长排队秒数
+	UploadRetries      int      `envconfig:"UPLOAD_RETRIES" file:"upload.retries" default:"3" flag:"upload-retries" desc:"Upload retries on another worker"`         // 上传失败后换worker重试的次数
+	UploadThreads      int      `envconfig:"UPLOAD_THREADS" file:"upload.threads" default:"4" flag:"upload-threads" desc:"Parts of a big file uploaded at the same time"`         // 单个大文件同时上传的分片数
+	UploadPartSize     int      `envconfig:"UPLOAD_PART_SIZE" file:"upload.part_size" default:"512" flag:"upload-part-size" desc:"Upload part size in KB, must divide 512"`     // 分片大小（KB），需能整除512
+	UploadConnections  int      `envconfig:"UPLOAD_CONNECTIONS" file:"upload.connections" default:"1" flag:"upload-connections" desc:"MTProto connections used to upload a big file"`     // 大文件上传使用的MTProto连接数，大于1时并行使用多个连接
+	EnableProtection   bool     `envconfig:"ENABLE_PROTECTION_MODE" file:"upload.protection_mode" default:"true" flag:"enable-protection" desc:"Enable protection mode"`
+	EnableDeepScan     bool     `envconfig:"ENABLE_DEEP_SCAN" file:"upload.deep_scan" default:"false" flag:"enable-deep-scan" desc:"Enable deep file scanning"`
 	
 	// 代理配置
-	TelegramProxy      string   `envconfig:"TELEGRAM_PROXY" default:""` // socks5://127.0.0.1:1080
+	TelegramProxy      string   `envconfig:"TELEGRAM_PROXY" file:"proxy.telegram" secret:"url" default:"" flag:"telegram-proxy" desc:"Proxy for all Telegram clients (socks5://, http(s):// or tg://proxy?...)"` // socks5://127.0.0.1:1080
+	WorkerProxies      []string `envconfig:"WORKER_PROXIES" file:"proxy.workers" secret:"url" flag:"worker-proxies" desc:"Proxies the worker bots are spread across"` // worker代理列表，逗号分隔，按worker依次分配
+
+	// 缓存配置
+	CacheSize          int      `envconfig:"CACHE_SIZE" file:"cache.size" default:"10" flag:"cache-size" desc:"File info cache size in MB"`  // 文件信息缓存大小（MB）
+	CacheTTL           int      `envconfig:"CACHE_TTL" file:"cache.ttl" default:"3600" flag:"cache-ttl" desc:"Seconds file info is cached"`  // 文件信息缓存秒数
 }
 
-var botTokenRegex = regexp.MustCompile(`MULTI\_TOKEN\d+=(.*)`)
+var botTokenRegex = regexp.MustCompile(`^MULTI\_TOKEN\d+=(.*)`)
 
 func (c *config) loadFromEnvFile(log *zap.Logger) {
 	envPath := filepath.Clean("fsb.env")
@@ -88,151 +119,97 @@ func (c *config) loadFromEnvFile(log *zap.Logger) {
 	}
 }
 
+// SetFlagsFromConfig registers a flag for every config field with a flag
+// tag, with the default of the field and its desc as usage.
 func SetFlagsFromConfig(cmd *cobra.Command) {
-	cmd.Flags().Int32("api-id", ValueOf.ApiID, "Telegram API ID")
-	cmd.Flags().String("api-hash", ValueOf.ApiHash, "Telegram API Hash")
-	cmd.Flags().String("bot-token", ValueOf.BotToken, "Telegram Bot Token")
-	cmd.Flags().Int64("log-channel", ValueOf.LogChannelID, "Telegram Log Channel ID")
-	cmd.Flags().Bool("dev", ValueOf.Dev, "Enable development mode")
-	cmd.Flags().IntP("port", "p", ValueOf.Port, "Server port")
-	cmd.Flags().String("host", ValueOf.Host, "Server host that will be included in links")
-	cmd.Flags().Int("hash-length", ValueOf.HashLength, "Hash length in links")
-	cmd.Flags().Bool("use-session-file", ValueOf.UseSessionFile, "Use session files")
-	cmd.Flags().String("user-session", ValueOf.UserSession, "Pyrogram user session")
-	cmd.Flags().Bool("use-public-ip", ValueOf.UsePublicIP, "Use public IP instead of local IP")
-	cmd.Flags().String("multi-token-txt-file", "", "Multi token txt file (Not implemented)")
-
-	// 上传API相关命令行参数
-	cmd.Flags().Bool("enable-upload-api", ValueOf.EnableUploadAPI, "Enable upload API")
-	cmd.Flags().String("upload-auth-token", ValueOf.UploadAuthToken, "Upload API authentication token")
-	cmd.Flags().Int64("max-file-size", ValueOf.MaxFileSize, "Maximum file size for upload (bytes)")
-	cmd.Flags().Int64("user-quota", ValueOf.UserQuota, "User storage quota (bytes)")
-	cmd.Flags().String("allowed-mime-types", ValueOf.AllowedMimeTypes, "Allowed MIME types for upload")
-	cmd.Flags().String("allowed-extensions", ValueOf.AllowedExtensions, "Allowed file extensions for upload")
-	cmd.Flags().Int("uploads-per-minute", ValueOf.UploadsPerMinute, "Uploads allowed per minute per user")
-	cmd.Flags().Int("uploads-per-hour", ValueOf.UploadsPerHour, "Uploads allowed per hour per user")
-	cmd.Flags().Int("concurrent-uploads", ValueOf.ConcurrentUploads, "Concurrent uploads per user")
-	cmd.Flags().Int("api-cooldown-seconds", ValueOf.APICooldownSeconds, "API cooldown seconds")
-	cmd.Flags().Bool("enable-protection", ValueOf.EnableProtection, "Enable protection mode")
-	cmd.Flags().Bool("enable-deep-scan", ValueOf.EnableDeepScan, "Enable deep file scanning")
+	flags := cmd.Flags()
+	flags.StringP("config", "c", "", "YAML or TOML config file, defaults to FSB_CONFIG or fsb.yaml, fsb.yml or fsb.toml if present")
+	for _, field := range flagFields() {
+		name, short, usage := field.Tag.Get("flag"), field.Tag.Get("short"), field.Tag.Get("desc")
+		value := field.Tag.Get("default")
+		switch field.Type.Kind() {
+		case reflect.Bool:
+			v, _ := strconv.ParseBool(value)
+			flags.BoolP(name, short, v, usage)
+		case reflect.Int:
+			v, _ := strconv.Atoi(value)
+			flags.IntP(name, short, v, usage)
+		case reflect.Int32:
+			v, _ := strconv.ParseInt(value, 10, 32)
+			flags.Int32P(name, short, int32(v), usage)
+		case reflect.Int64:
+			v, _ := strconv.ParseInt(value, 10, 64)
+			flags.Int64P(name, short, v, usage)
+		case reflect.String:
+			flags.StringP(name, short, value, usage)
+		case reflect.Slice:
+			var v []string
+			if value != "" {
+				v = strings.Split(value, ",")
+			}
+			flags.StringSliceP(name, short, v, usage)
+		default:
+			panic(fmt.Sprintf("config: unsupported flag type %s of %s", field.Type, field.Name))
+		}
+	}
 }
 
-func (c *config) loadConfigFromArgs(log *zap.Logger, cmd *cobra.Command) {
-	apiID, _ := cmd.Flags().GetInt32("api-id")
-	if apiID != 0 {
-		os.Setenv("API_ID", strconv.Itoa(int(apiID)))
-	}
-	apiHash, _ := cmd.Flags().GetString("api-hash")
-	if apiHash != "" {
-		os.Setenv("API_HASH", apiHash)
-	}
-	botToken, _ := cmd.Flags().GetString("bot-token")
-	if botToken != "" {
-		os.Setenv("BOT_TOKEN", botToken)
-	}
-	logChannelID, _ := cmd.Flags().GetString("log-channel")
-	if logChannelID != "" {
-		os.Setenv("LOG_CHANNEL", logChannelID)
-	}
-	dev, _ := cmd.Flags().GetBool("dev")
-	if dev {
-		os.Setenv("DEV", strconv.FormatBool(dev))
-	}
-	port, _ := cmd.Flags().GetInt("port")
-	if port != 0 {
-		os.Setenv("PORT", strconv.Itoa(port))
-	}
-	host, _ := cmd.Flags().GetString("host")
-	if host != "" {
-		os.Setenv("HOST", host)
-	}
-	hashLength, _ := cmd.Flags().GetInt("hash-length")
-	if hashLength != 0 {
-		os.Setenv("HASH_LENGTH", strconv.Itoa(hashLength))
-	}
-	useSessionFile, _ := cmd.Flags().GetBool("use-session-file")
-	if useSessionFile {
-		os.Setenv("USE_SESSION_FILE", strconv.FormatBool(useSessionFile))
-	}
-	userSession, _ := cmd.Flags().GetString("user-session")
-	if userSession != "" {
-		os.Setenv("USER_SESSION", userSession)
-	}
-	usePublicIP, _ := cmd.Flags().GetBool("use-public-ip")
-	if usePublicIP {
-		os.Setenv("USE_PUBLIC_IP", strconv.FormatBool(usePublicIP))
-	}
-	multiTokens, _ := cmd.Flags().GetString("multi-token-txt-file")
-	if multiTokens != "" {
-		os.Setenv("MULTI_TOKEN_TXT_FILE", multiTokens)
-		// TODO: Add support for importing tokens from a separate file
+// flagFields returns the config fields that can be set with a flag.
+func flagFields() []reflect.StructField {
+	var fields []reflect.StructField
+	t := reflect.TypeOf(config{})
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("flag") != "" {
+			fields = append(fields, t.Field(i))
+		}
 	}
+	return fields
+}
 
-	// 上传API配置处理
-	enableUploadAPI, _ := cmd.Flags().GetBool("enable-upload-api")
-	if enableUploadAPI {
-		os.Setenv("ENABLE_UPLOAD_API", strconv.FormatBool(enableUploadAPI))
-	}
-	uploadAuthToken, _ := cmd.Flags().GetString("upload-auth-token")
-	if uploadAuthToken != "" {
-		os.Setenv("UPLOAD_AUTH_TOKEN", uploadAuthToken)
-	}
-	maxFileSize, _ := cmd.Flags().GetInt64("max-file-size")
-	if maxFileSize != 0 {
-		os.Setenv("MAX_FILE_SIZE", strconv.FormatInt(maxFileSize, 10))
-	}
-	userQuota, _ := cmd.Flags().GetInt64("user-quota")
-	if userQuota != 0 {
-		os.Setenv("USER_QUOTA", strconv.FormatInt(userQuota, 10))
-	}
-	allowedMimeTypes, _ := cmd.Flags().GetString("allowed-mime-types")
-	if allowedMimeTypes != "" {
-		os.Setenv("ALLOWED_MIME_TYPES", allowedMimeTypes)
-	}
-	allowedExtensions, _ := cmd.Flags().GetString("allowed-extensions")
-	if allowedExtensions != "" {
-		os.Setenv("ALLOWED_EXTENSIONS", allowedExtensions)
-	}
-	uploadsPerMinute, _ := cmd.Flags().GetInt("uploads-per-minute")
-	if uploadsPerMinute != 0 {
-		os.Setenv("UPLOADS_PER_MINUTE", strconv.Itoa(uploadsPerMinute))
-	}
-	uploadsPerHour, _ := cmd.Flags().GetInt("uploads-per-hour")
-	if uploadsPerHour != 0 {
-		os.Setenv("UPLOADS_PER_HOUR", strconv.Itoa(uploadsPerHour))
-	}
-	concurrentUploads, _ := cmd.Flags().GetInt("concurrent-uploads")
-	if concurrentUploads != 0 {
-		os.Setenv("CONCURRENT_UPLOADS_PER_USER", strconv.Itoa(concurrentUploads))
-	}
-	apiCooldownSeconds, _ := cmd.Flags().GetInt("api-cooldown-seconds")
-	if apiCooldownSeconds != 0 {
-		os.Setenv("API_COOLDOWN_SECONDS", strconv.Itoa(apiCooldownSeconds))
-	}
-	enableProtection, _ := cmd.Flags().GetBool("enable-protection")
-	if enableProtection {
-		os.Setenv("ENABLE_PROTECTION_MODE", strconv.FormatBool(enableProtection))
-	}
-	enableDeepScan, _ := cmd.Flags().GetBool("enable-deep-scan")
-	if enableDeepScan {
-		os.Setenv("ENABLE_DEEP_SCAN", strconv.FormatBool(enableDeepScan))
+// loadConfigFromArgs copies the flags given on the command line into their
+// env variables, so that they take precedence over the env and the config
+// file. Flags that weren't given are left alone, whatever their value.
+func (c *config) loadConfigFromArgs(log *zap.Logger, cmd *cobra.Command) {
+	for _, field := range flagFields() {
+		flag := cmd.Flags().Lookup(field.Tag.Get("flag"))
+		if flag == nil || !flag.Changed {
+			continue
+		}
+		value := flag.Value.String()
+		if slice, ok := flag.Value.(pflag.SliceValue); ok {
+			value = strings.Join(slice.GetSlice(), ",")
+		}
+		log.Debug("Using flag", zap.String("flag", flag.Name))
+		os.Setenv(field.Tag.Get("envconfig"), value)
 	}
 }
 
-func (c *config) setupEnvVars(log *zap.Logger, cmd *cobra.Command) {
+// setupEnvVars reads the config into c. The HOST is only detected when
+// detectHost is set since it takes network calls.
+func (c *config) setupEnvVars(log *zap.Logger, cmd *cobra.Command, detectHost bool) error {
+	captureProcessTokens()
 	c.loadFromEnvFile(log)
-	c.loadConfigFromArgs(log, cmd)
-	err := envconfig.Process("", c)
-	if err != nil {
-		log.Fatal("Error while parsing env variables", zap.Error(err))
+	var errs []error
+	configFile, _ := cmd.Flags().GetString("config")
+	if path, explicit := configFilePath(configFile); path != "" {
+		log.Sugar().Infof("Loading config file %s", path)
+		fileEnv, err := readConfigFile(path)
+		if err != nil && (explicit || !os.IsNotExist(err)) {
+			errs = append(errs, err)
+		}
+		applyConfigFile(fileEnv)
 	}
-	var ipBlocked bool
-	ip, err := getIP(c.UsePublicIP)
-	if err != nil {
-		log.Error("Error while getting IP", zap.Error(err))
-		ipBlocked = true
+	c.loadConfigFromArgs(log, cmd)
+	if err := c.process(); err != nil {
+		errs = append(errs, err)
 	}
-	if c.Host == "" {
+	if c.Host == "" && detectHost {
+		var ipBlocked bool
+		ip, err := detectIP(c.UsePublicIP)
+		if err != nil {
+			log.Error("Error while getting IP", zap.Error(err))
+			ipBlocked = true
+		}
 		c.Host = "http://" + ip + ":" + strconv.Itoa(c.Port)
 		if c.UsePublicIP {
 			if ipBlocked {
@@ -244,20 +221,35 @@ func (c *config) setupEnvVars(log *zap.Logger, cmd *cobra.Command) {
 		}
 		log.Sugar().Info("HOST not set, automatically set to " + c.Host)
 	}
-	val := reflect.ValueOf(c).Elem()
-	for _, env := range os.Environ() {
-		if strings.HasPrefix(env, "MULTI_TOKEN") {
-			c.MultiTokens = append(c.MultiTokens, botTokenRegex.FindStringSubmatch(env)[1])
-		}
+	fileEnv, _ := godotenv.Read(filepath.Clean("fsb.env"))
+	var err error
+	c.MultiTokens, err = collectTokens(log, fileEnv)
+	if err != nil {
+		log.Error("Some worker tokens were skipped", zap.Error(err))
 	}
-	val.FieldByName("MultiTokens").Set(reflect.ValueOf(c.MultiTokens))
+	return errors.Join(errs...)
 }
 
+// Load reads the config from the flags, the env, the config file and the
+// defaults, in that order of precedence, and exits if it is invalid.
 func Load(log *zap.Logger, cmd *cobra.Command) {
 	log = log.Named("Config")
-	defer log.Info("Loaded config")
-	ValueOf.setupEnvVars(log, cmd)
-	ValueOf.LogChannelID = int64(stripInt(log, int(ValueOf.LogChannelID)))
+	if err := load(log, cmd, true); err != nil {
+		log.Fatal("Invalid config", zap.Error(err))
+	}
+	log.Info("Loaded config")
+}
+
+// Check loads the config like Load but returns the errors instead of
+// exiting, for `fsb config check`. HOST isn't detected when it is not set.
+func Check(log *zap.Logger, cmd *cobra.Command) error {
+	return load(log.Named("Config"), cmd, false)
+}
+
+func load(log *zap.Logger, cmd *cobra.Command, detectHost bool) error {
+	err := ValueOf.setupEnvVars(log, cmd, detectHost)
+	logChannelID, logChannelErr := stripInt(int(ValueOf.LogChannelID))
+	ValueOf.LogChannelID = int64(logChannelID)
 	if ValueOf.HashLength == 0 {
 		log.Sugar().Info("HASH_LENGTH can't be 0, defaulting to 6")
 		ValueOf.HashLength = 6
@@ -270,8 +262,78 @@ func Load(log *zap.Logger, cmd *cobra.Command) {
 		log.Sugar().Info("HASH_LENGTH can't be less than 5, defaulting to 6")
 		ValueOf.HashLength = 6
 	}
+	switch ValueOf.WorkerStrategy {
+	case "round-robin", "least-connections", "weighted", "sticky":
+	default:
+		log.Sugar().Infof("Unknown WORKER_STRATEGY %q, defaulting to round-robin", ValueOf.WorkerStrategy)
+		ValueOf.WorkerStrategy = "round-robin"
+	}
+	if ValueOf.UploadThreads < 1 {
+		log.Sugar().Info("UPLOAD_THREADS can't be less than 1, changing to 1")
+		ValueOf.UploadThreads = 1
+	}
+	// Telegram requires 512 KB to be divisible by the part size
+	if ValueOf.UploadPartSize < 1 || 512%ValueOf.UploadPartSize != 0 {
+		log.Sugar().Infof("UPLOAD_PART_SIZE must divide 512, got %d, defaulting to 512", ValueOf.UploadPartSize)
+		ValueOf.UploadPartSize = 512
+	}
+	ValueOf.fitUploadParts(log)
+	if ValueOf.UploadConnections < 1 {
+		log.Sugar().Info("UPLOAD_CONNECTIONS can't be less than 1, changing to 1")
+		ValueOf.UploadConnections = 1
+	}
+	if ValueOf.DrainTimeout < 0 {
+		log.Sugar().Info("SHUTDOWN_TIMEOUT can't be negative, changing to 0")
+		ValueOf.DrainTimeout = 0
+	}
+	if ValueOf.LogLevel == "" {
+		ValueOf.LogLevel = "info"
+		if ValueOf.Dev {
+			ValueOf.LogLevel = "debug"
+		}
+	}
+	return errors.Join(err, logChannelErr, ValueOf.validate())
 }
 
+// Telegram accepts at most 4000 parts of at most 512 KB per file
+const (
+	maxUploadParts    = 4000
+	maxUploadPartSize = 512
+)
+
+// fitUploadParts makes sure a file of MAX_FILE_SIZE can be uploaded in at
+// most maxUploadParts parts, raising UPLOAD_PART_SIZE or, once it is at
+// 512 KB already, lowering MAX_FILE_SIZE.
+func (c *config) fitUploadParts(log *zap.Logger) {
+	if c.MaxFileSize < 1 {
+		return
+	}
+	parts := func(partSize int) int64 {
+		size := int64(partSize) * 1024
+		return (c.MaxFileSize + size - 1) / size
+	}
+	if parts(c.UploadPartSize) <= maxUploadParts {
+		return
+	}
+	if parts(maxUploadPartSize) > maxUploadParts {
+		limit := int64(maxUploadParts) * maxUploadPartSize * 1024
+		log.Sugar().Infof("MAX_FILE_SIZE can't be more than %d bytes (%d parts of %d KB), changing to %d", limit, maxUploadParts, maxUploadPartSize, limit)
+		c.MaxFileSize = limit
+	}
+	partSize := c.UploadPartSize
+	// part sizes dividing 512 are powers of two
+	for parts(partSize) > maxUploadParts {
+		partSize *= 2
+	}
+	if partSize != c.UploadPartSize {
+		log.Sugar().Infof("UPLOAD_PART_SIZE %d KB needs more than %d parts for MAX_FILE_SIZE, changing to %d", c.UploadPartSize, maxUploadParts, partSize)
+		c.UploadPartSize = partSize
+	}
+}
+
+// detectIP finds the IP used for the HOST when it is not set, tests replace it.
+var detectIP = getIP
+
 func getIP(public bool) (string, error) {
 	var ip string
 	var err error
@@ -325,15 +387,15 @@ func checkIfIpAccessible(ip string) bool {
 	return true
 }
 
-func stripInt(log *zap.Logger, a int) int {
+// stripInt turns a channel ID like -1001234 into the 1234 Telegram uses.
+func stripInt(a int) (int, error) {
 	strA := strconv.Itoa(abs(a))
 	lastDigits := strings.Replace(strA, "100", "", 1)
 	result, err := strconv.Atoi(lastDigits)
 	if err != nil {
-		log.Sugar().Fatalln(err)
-		return 0
+		return 0, fmt.Errorf("LOG_CHANNEL: invalid channel ID %d", a)
 	}
-	return result
+	return result, nil
 }
 
 func abs(x int) int {
@@ -0,0 +1,189 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+// requiredEnv satisfies the required fields so that process succeeds.
+func requiredEnv(t *testing.T) {
+	unsetEnv(t)
+	t.Setenv("API_ID", "1")
+	t.Setenv("API_HASH", "hash")
+	t.Setenv("BOT_TOKEN", "1:token")
+	t.Setenv("LOG_CHANNEL", "-1001")
+}
+
+// parseFlags loads the config as `fsb run args...` would.
+func parseFlags(t *testing.T, args ...string) *config {
+	cmd := &cobra.Command{Use: "run"}
+	SetFlagsFromConfig(cmd)
+	if err := cmd.ParseFlags(args); err != nil {
+		t.Fatal(err)
+	}
+	var c config
+	c.loadConfigFromArgs(zap.NewNop(), cmd)
+	if err := c.process(); err != nil {
+		t.Fatal(err)
+	}
+	return &c
+}
+
+// flagValue returns a value other than the default of field, the zero value
+// if possible, and the value it should be parsed into.
+func flagValue(field reflect.StructField) (string, any) {
+	def := field.Tag.Get("default")
+	switch field.Type.Kind() {
+	case reflect.Bool:
+		v, _ := strconv.ParseBool(def)
+		return strconv.FormatBool(!v), !v
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		value := int64(0)
+		if def == "" || def == "0" {
+			value = 7
+		}
+		return strconv.FormatInt(value, 10), reflect.ValueOf(value).Convert(field.Type).Interface()
+	case reflect.Slice:
+		return "a,b", []string{"a", "b"}
+	default:
+		value := "flag-" + field.Tag.Get("flag")
+		return value, value
+	}
+}
+
+func TestFlags(t *testing.T) {
+	fields := flagFields()
+	if len(fields) == 0 {
+		t.Fatal("no flags")
+	}
+	for _, field := range fields {
+		name := field.Tag.Get("flag")
+		t.Run(name, func(t *testing.T) {
+			requiredEnv(t)
+			arg, want := flagValue(field)
+			// The flag takes precedence over the env
+			env := field.Tag.Get("envconfig")
+			if field.Type.Kind() == reflect.Bool {
+				t.Setenv(env, fmt.Sprint(!want.(bool)))
+			}
+
+			c := parseFlags(t, "--"+name+"="+arg)
+			got := reflect.ValueOf(c).Elem().FieldByName(field.Name).Interface()
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("--%s=%s: %s = %#v, want %#v", name, arg, field.Name, got, want)
+			}
+			if field.Tag.Get("desc") == "" {
+				t.Errorf("--%s has no usage", name)
+			}
+		})
+	}
+}
+
+func TestFlagsNotGiven(t *testing.T) {
+	requiredEnv(t)
+	t.Setenv("USE_SESSION_FILE", "false")
+	t.Setenv("PORT", "9090")
+
+	c := parseFlags(t, "--log-channel=-1002", "-p", "0", "--trusted-proxies=")
+	if c.UseSessionFile {
+		t.Error("a flag that wasn't given overrode USE_SESSION_FILE")
+	}
+	if c.Port != 0 {
+		t.Errorf("expected -p 0 to override PORT, got %d", c.Port)
+	}
+	if c.LogChannelID != -1002 {
+		t.Errorf("expected --log-channel to be used, got %d", c.LogChannelID)
+	}
+	if len(c.TrustedProxies) != 0 {
+		t.Errorf("expected no trusted proxies, got %v", c.TrustedProxies)
+	}
+	if c.CacheTTL != 3600 {
+		t.Errorf("expected the default cache ttl, got %d", c.CacheTTL)
+	}
+}
+
+func TestFitUploadParts(t *testing.T) {
+	const mb = 1024 * 1024
+	tests := []struct {
+		name         string
+		maxFileSize  int64
+		partSize     int
+		wantMaxSize  int64
+		wantPartSize int
+	}{
+		{"default", 2097152000, 512, 2097152000, 512},
+		{"fits", 50 * mb, 16, 50 * mb, 16},
+		{"exactly 4000 parts", 4000 * 64 * 1024, 64, 4000 * 64 * 1024, 64},
+		{"one part too many", 4000*64*1024 + 1, 64, 4000*64*1024 + 1, 128},
+		{"part size raised", 1000 * mb, 32, 1000 * mb, 256},
+		{"2 GiB", 2048 * mb, 512, 2097152000, 512},
+		{"4 GiB with small parts", 4096 * mb, 1, 2097152000, 512},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := config{MaxFileSize: test.maxFileSize, UploadPartSize: test.partSize}
+			c.fitUploadParts(zap.NewNop())
+			if c.MaxFileSize != test.wantMaxSize || c.UploadPartSize != test.wantPartSize {
+				t.Fatalf("got MAX_FILE_SIZE %d and UPLOAD_PART_SIZE %d, want %d and %d",
+					c.MaxFileSize, c.UploadPartSize, test.wantMaxSize, test.wantPartSize)
+			}
+			parts := (c.MaxFileSize + int64(c.UploadPartSize)*1024 - 1) / (int64(c.UploadPartSize) * 1024)
+			if parts > maxUploadParts {
+				t.Fatalf("%d parts needed", parts)
+			}
+		})
+	}
+}
+
+func TestStripInt(t *testing.T) {
+	tests := []struct {
+		in      int
+		want    int
+		wantErr bool
+	}{
+		{-1001234567890, 1234567890, false},
+		{1001234, 1234, false},
+		{1234, 1234, false},
+		{0, 0, false},
+		{-100, 0, true},
+	}
+	for _, test := range tests {
+		got, err := stripInt(test.in)
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("stripInt(%d) = %d, %v, want %d, error %v", test.in, got, err, test.want, test.wantErr)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	t.Chdir(t.TempDir())
+	requiredEnv(t)
+	t.Setenv("LOG_CHANNEL", "-100")
+	old := *ValueOf
+	t.Cleanup(func() { *ValueOf = old })
+	oldDetectIP := detectIP
+	t.Cleanup(func() { detectIP = oldDetectIP })
+	detectIP = func(bool) (string, error) {
+		t.Error("config check detected the host")
+		return "localhost", nil
+	}
+
+	cmd := &cobra.Command{Use: "check"}
+	SetFlagsFromConfig(cmd)
+	if err := cmd.ParseFlags(nil); err != nil {
+		t.Fatal(err)
+	}
+	err := Check(zap.NewNop(), cmd)
+	if err == nil || !strings.Contains(err.Error(), "LOG_CHANNEL: invalid channel ID -100") {
+		t.Fatalf("expected a LOG_CHANNEL error, got %v", err)
+	}
+	if ValueOf.Host != "" {
+		t.Errorf("expected HOST to stay empty, got %q", ValueOf.Host)
+	}
+}
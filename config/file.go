@@ -0,0 +1,372 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"EverythingSuckz/fsb/pkg/sessionstring"
+
+	"github.com/joho/godotenv"
+	"github.com/kelseyhightower/envconfig"
+	"github.com/pelletier/go-toml/v2"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultConfigFiles are looked for in the working directory when no config
+// file is given with --config or FSB_CONFIG.
+var DefaultConfigFiles = []string{"fsb.yaml", "fsb.yml", "fsb.toml"}
+
+// configFilePath returns the config file to read and whether it was asked
+// for explicitly. It is empty if there is none.
+func configFilePath(path string) (string, bool) {
+	if path == "" {
+		path = os.Getenv("FSB_CONFIG")
+	}
+	if path != "" {
+		return path, true
+	}
+	for _, name := range DefaultConfigFiles {
+		if _, err := os.Stat(name); err == nil {
+			return name, false
+		}
+	}
+	return "", false
+}
+
+// readConfigFile parses a YAML or TOML config file into the env variables
+// of its keys, e.g. upload.threads into UPLOAD_THREADS.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]any{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".toml":
+		err = toml.Unmarshal(data, &values)
+	default:
+		return nil, fmt.Errorf("%s: unknown config file format, expected .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	keys := fileKeys()
+	env := make(map[string]string)
+	var errs []error
+	var walk func(prefix string, values map[string]any)
+	walk = func(prefix string, values map[string]any) {
+		for _, key := range sortedKeys(values) {
+			value := values[key]
+			key = prefix + key
+			envKey, known := keys[key]
+			if section, ok := value.(map[string]any); ok && !known {
+				walk(key+".", section)
+				continue
+			}
+			if !known && value == nil && isSection(keys, key) {
+				// A section with all its keys commented out
+				continue
+			}
+			if !known {
+				errs = append(errs, fmt.Errorf("%s: unknown key %q", path, key))
+				continue
+			}
+			str, err := fileValue(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %s: %w", path, key, err))
+				continue
+			}
+			env[envKey] = str
+		}
+	}
+	walk("", values)
+	return env, errors.Join(errs...)
+}
+
+// fileValue formats a config file value the way it would be written in an
+// env variable, lists being comma separated.
+func fileValue(value any) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			str, err := fileValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, str)
+		}
+		return strings.Join(items, ","), nil
+	case map[string]any:
+		return "", errors.New("expected a value, got a section")
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
+
+// fileKeys maps the config file keys to their env variables.
+func fileKeys() map[string]string {
+	keys := make(map[string]string)
+	t := reflect.TypeOf(config{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if key := field.Tag.Get("file"); key != "" {
+			keys[key] = field.Tag.Get("envconfig")
+		}
+	}
+	return keys
+}
+
+func isSection(keys map[string]string, name string) bool {
+	for key := range keys {
+		if strings.HasPrefix(key, name+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// applyConfigFile sets the env variables of the config file that aren't set
+// already, so that env variables and flags take precedence over the file.
+func applyConfigFile(env map[string]string) {
+	for key, value := range env {
+		if _, ok := os.LookupEnv(key); !ok {
+			os.Setenv(key, value)
+		}
+	}
+}
+
+// readEnvSources reads fsb.env and the config file, which is configFile,
+// FSB_CONFIG or a default one, for the commands that don't load the whole
+// config.
+func readEnvSources(configFile string) (fileEnv, configEnv map[string]string, err error) {
+	fileEnv, err = godotenv.Read(filepath.Clean("fsb.env"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, fmt.Errorf("fsb.env: %w", err)
+	}
+	if path, explicit := configFilePath(configFile); path != "" {
+		configEnv, err = readConfigFile(path)
+		if err != nil && (explicit || !os.IsNotExist(err)) {
+			return nil, nil, err
+		}
+	}
+	return fileEnv, configEnv, nil
+}
+
+// lookupEnv returns an env variable with the precedence of setupEnvVars:
+// the environment, then fsb.env and then the config file.
+func lookupEnv(key string, fileEnv, configEnv map[string]string) (string, bool) {
+	if value, ok := os.LookupEnv(key); ok {
+		return value, true
+	}
+	if value, ok := fileEnv[key]; ok {
+		return value, true
+	}
+	value, ok := configEnv[key]
+	return value, ok
+}
+
+// LookupEnv returns the value of one env variable as the config would have
+// it, from the environment, fsb.env or the config file, without loading the
+// rest of the config.
+func LookupEnv(key, configFile string) (string, bool, error) {
+	fileEnv, configEnv, err := readEnvSources(configFile)
+	if err != nil {
+		return "", false, err
+	}
+	value, ok := lookupEnv(key, fileEnv, configEnv)
+	return value, ok, nil
+}
+
+// process parses every field on its own so that all the invalid env
+// variables are reported, not only the first one.
+func (c *config) process() error {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	var errs []error
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Tag.Get("ignored") == "true" {
+			continue
+		}
+		one := reflect.New(reflect.StructOf([]reflect.StructField{{
+			Name: field.Name,
+			Type: field.Type,
+			Tag:  field.Tag,
+		}}))
+		if err := envconfig.Process("", one.Interface()); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		v.Field(i).Set(one.Elem().Field(0))
+	}
+	return errors.Join(errs...)
+}
+
+// validate reports the values that can't be used, all at once.
+func (c *config) validate() error {
+	var errs []error
+	if c.Port < 1 || c.Port > 65535 {
+		errs = append(errs, fmt.Errorf("PORT must be between 1 and 65535, got %d", c.Port))
+	}
+	switch strings.ToLower(c.LogFormat) {
+	case "console", "json":
+	default:
+		errs = append(errs, fmt.Errorf("LOG_FORMAT must be console or json, got %q", c.LogFormat))
+	}
+	for _, part := range strings.Split(c.LogLevel, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		level := part
+		if i := strings.LastIndex(part, "="); i != -1 {
+			level = strings.TrimSpace(part[i+1:])
+		}
+		// ParseLevel takes an empty level for info
+		if _, err := zapcore.ParseLevel(level); err != nil || level == "" {
+			errs = append(errs, fmt.Errorf("LOG_LEVEL: invalid level %q", part))
+		}
+	}
+	for _, proxy := range c.TrustedProxies {
+		if net.ParseIP(proxy) == nil {
+			if _, _, err := net.ParseCIDR(proxy); err != nil {
+				errs = append(errs, fmt.Errorf("TRUSTED_PROXIES: %q is neither an IP nor a CIDR", proxy))
+			}
+		}
+	}
+	for _, proxy := range append([]string{c.TelegramProxy}, c.WorkerProxies...) {
+		for _, part := range strings.Split(proxy, ",") {
+			if part = strings.TrimSpace(part); part == "" {
+				continue
+			}
+			u, err := url.Parse(part)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid proxy URL %q", redactURL(part)))
+				continue
+			}
+			switch u.Scheme {
+			case "socks5", "socks5h", "http", "https", "tg":
+			default:
+				errs = append(errs, fmt.Errorf("unsupported proxy scheme in %q, expected socks5, http, https or tg", redactURL(part)))
+			}
+		}
+	}
+	if c.MaxFileSize < 1 {
+		errs = append(errs, fmt.Errorf("MAX_FILE_SIZE must be positive, got %d", c.MaxFileSize))
+	}
+	if c.UserQuota < 0 {
+		errs = append(errs, fmt.Errorf("USER_QUOTA can't be negative, got %d", c.UserQuota))
+	}
+	if c.CacheSize < 1 {
+		errs = append(errs, fmt.Errorf("CACHE_SIZE must be at least 1 MB, got %d", c.CacheSize))
+	}
+	if c.CacheTTL < 0 {
+		errs = append(errs, fmt.Errorf("CACHE_TTL can't be negative, got %d", c.CacheTTL))
+	}
+	if c.UserSession != "" {
+		if _, err := sessionstring.Decode(c.UserSession); err != nil {
+			errs = append(errs, fmt.Errorf("USER_SESSION: %w", err))
+		}
+	}
+	if c.EnableUploadAPI && c.UploadAuthToken == "" {
+		errs = append(errs, errors.New("UPLOAD_AUTH_TOKEN is required when ENABLE_UPLOAD_API is set"))
+	}
+	return errors.Join(errs...)
+}
+
+// Marshal returns the effective config as a YAML config file. Secrets are
+// masked if redacted is set.
+func Marshal(redacted bool) ([]byte, error) {
+	out := make(map[string]any)
+	v := reflect.ValueOf(ValueOf).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := field.Tag.Get("file")
+		if key == "" {
+			continue
+		}
+		value := v.Field(i).Interface()
+		if redacted {
+			value = redactValue(field.Tag.Get("secret"), value)
+		}
+		section := out
+		parts := strings.Split(key, ".")
+		for _, part := range parts[:len(parts)-1] {
+			next, ok := section[part].(map[string]any)
+			if !ok {
+				next = make(map[string]any)
+				section[part] = next
+			}
+			section = next
+		}
+		section[parts[len(parts)-1]] = value
+	}
+	return yaml.Marshal(out)
+}
+
+func redactValue(secret string, value any) any {
+	switch secret {
+	case "true":
+		if reflect.ValueOf(value).IsZero() {
+			return value
+		}
+		return "xxxxx"
+	case "url":
+		switch v := value.(type) {
+		case string:
+			return redactURLs(v)
+		case []string:
+			out := make([]string, len(v))
+			for i, item := range v {
+				out[i] = redactURLs(item)
+			}
+			return out
+		}
+	}
+	return value
+}
+
+func redactURLs(value string) string {
+	parts := strings.Split(value, ",")
+	for i, part := range parts {
+		parts[i] = redactURL(strings.TrimSpace(part))
+	}
+	return strings.Join(parts, ",")
+}
+
+// redactURL masks the password and the MTProxy secret of a proxy URL.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "<invalid proxy URL>"
+	}
+	if query := u.Query(); query.Has("secret") {
+		query.Set("secret", "xxxxx")
+		u.RawQuery = query.Encode()
+	}
+	return u.Redacted()
+}
+
+// sortedKeys is used to report the config file errors in a stable order.
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
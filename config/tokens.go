@@ -0,0 +1,202 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"go.uber.org/zap"
+)
+
+// WorkerToken is a worker bot token along with the label used for it in
+// logs and stats.
+type WorkerToken struct {
+	Label string
+	Token string
+}
+
+// BotID returns the ID of the bot, the part of the token before the colon.
+func (t WorkerToken) BotID() int64 {
+	id, _, _ := strings.Cut(t.Token, ":")
+	botID, _ := strconv.ParseInt(id, 10, 64)
+	return botID
+}
+
+var (
+	tokenRegex = regexp.MustCompile(`^\d+:[A-Za-z0-9_-]{30,}$`)
+	labelRegex = regexp.MustCompile(`^[A-Za-z0-9_.@-]+$`)
+)
+
+// MULTI_TOKEN* variables set by the environment itself rather than fsb.env,
+// captured before the env file is loaded so a reload can tell them apart.
+var processTokens map[string]string
+
+func captureProcessTokens() {
+	processTokens = make(map[string]string)
+	for _, env := range os.Environ() {
+		if match := botTokenRegex.FindStringSubmatch(env); match != nil {
+			key, _, _ := strings.Cut(env, "=")
+			processTokens[key] = match[1]
+		}
+	}
+}
+
+// envTokens returns the MULTI_TOKEN* values of env sorted by their number,
+// labelled with their variable name.
+func envTokens(env map[string]string) ([]WorkerToken, error) {
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		if botTokenRegex.MatchString(key + "=") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) < len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+	var errs []error
+	tokens := make([]WorkerToken, 0, len(keys))
+	for _, key := range keys {
+		token := strings.TrimSpace(env[key])
+		if !tokenRegex.MatchString(token) {
+			errs = append(errs, fmt.Errorf("%s: invalid bot token %q", key, maskToken(token)))
+			continue
+		}
+		tokens = append(tokens, WorkerToken{Label: key, Token: token})
+	}
+	return tokens, errors.Join(errs...)
+}
+
+// readTokenFile reads worker tokens from a file with one token per line.
+// A token can be labelled as name=token, blank lines and # comments are
+// ignored.
+func readTokenFile(path string) ([]WorkerToken, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var (
+		tokens []WorkerToken
+		errs   []error
+		lineNo int
+	)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineNo++
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		label := fmt.Sprintf("%s:%d", filepath.Base(path), lineNo)
+		token := line
+		if name, value, ok := strings.Cut(line, "="); ok {
+			label, token = strings.TrimSpace(name), strings.TrimSpace(value)
+			if !labelRegex.MatchString(label) {
+				errs = append(errs, fmt.Errorf("%s:%d: invalid label %q, use letters, digits and _.@-", path, lineNo, label))
+				continue
+			}
+		}
+		if !tokenRegex.MatchString(token) {
+			errs = append(errs, fmt.Errorf("%s:%d: invalid bot token %q, expected <bot id>:<secret>", path, lineNo, maskToken(token)))
+			continue
+		}
+		tokens = append(tokens, WorkerToken{Label: label, Token: token})
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	return tokens, errors.Join(errs...)
+}
+
+// maskToken hides the secret part of a token for error messages.
+func maskToken(token string) string {
+	if id, _, ok := strings.Cut(token, ":"); ok {
+		return id + ":***"
+	}
+	if len(token) > 6 {
+		return token[:6] + "***"
+	}
+	return token
+}
+
+// collectTokens merges the tokens of the environment, the env file and the
+// multi token txt file. Duplicated tokens are dropped, the first label wins.
+// Invalid entries are skipped and reported in the returned error.
+func collectTokens(log *zap.Logger, fileEnv map[string]string) ([]WorkerToken, error) {
+	env := make(map[string]string, len(fileEnv)+len(processTokens))
+	for key, value := range fileEnv {
+		env[key] = value
+	}
+	// like godotenv.Load, the real environment wins over fsb.env
+	for key, value := range processTokens {
+		env[key] = value
+	}
+	tokens, err := envTokens(env)
+	errs := []error{err}
+	if path := ValueOf.MultiTokenFile; path != "" {
+		fileTokens, err := readTokenFile(path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("multi token file: %w", err))
+		}
+		tokens = append(tokens, fileTokens...)
+	}
+	seenTokens := make(map[string]string, len(tokens))
+	seenLabels := make(map[string]bool, len(tokens))
+	unique := tokens[:0]
+	for _, token := range tokens {
+		if label, ok := seenTokens[token.Token]; ok {
+			log.Sugar().Warnf("Worker token %s is the same as %s, ignoring it", token.Label, label)
+			continue
+		}
+		if seenLabels[token.Label] {
+			errs = append(errs, fmt.Errorf("%s: label used by another token", token.Label))
+			continue
+		}
+		seenTokens[token.Token] = token.Label
+		seenLabels[token.Label] = true
+		unique = append(unique, token)
+	}
+	return unique, errors.Join(errs...)
+}
+
+// ReloadTokens re-reads the worker bot tokens from fsb.env and the multi
+// token txt file. ValueOf.MultiTokens is only updated if every entry is valid.
+func ReloadTokens(log *zap.Logger) ([]WorkerToken, error) {
+	log = log.Named("Config")
+	fileEnv, err := godotenv.Read(filepath.Clean("fsb.env"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("fsb.env: %w", err)
+	}
+	tokens, err := collectTokens(log, fileEnv)
+	if err != nil {
+		return nil, err
+	}
+	ValueOf.MultiTokens = tokens
+	log.Sugar().Infof("Reloaded %d worker tokens", len(tokens))
+	return tokens, nil
+}
+
+// LoadTokens reads the worker bot tokens without loading the rest of the
+// config, for commands that don't start the bots. The multi token txt file is
+// looked up like setupEnvVars does: the environment, fsb.env and then the
+// config file, which is configFile, FSB_CONFIG or a default one.
+func LoadTokens(log *zap.Logger, configFile string) ([]WorkerToken, error) {
+	captureProcessTokens()
+	fileEnv, configEnv, err := readEnvSources(configFile)
+	if err != nil {
+		return nil, err
+	}
+	ValueOf.MultiTokenFile, _ = lookupEnv("MULTI_TOKEN_TXT_FILE", fileEnv, configEnv)
+	return collectTokens(log, fileEnv)
+}
@@ -0,0 +1,215 @@
+package config
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// testToken returns a valid bot token for the bot id.
+func testToken(id string) string {
+	return id + ":AAbbCCddEEffGGhhIIjjKKllMMnnOOpp_-"
+}
+
+func tokenLabels(tokens []WorkerToken) string {
+	labels := make([]string, len(tokens))
+	for i, token := range tokens {
+		labels[i] = token.Label + "=" + token.Token
+	}
+	return strings.Join(labels, ",")
+}
+
+func TestReadTokenFile(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []WorkerToken
+		errs []string
+	}{
+		{
+			name: "plain tokens",
+			data: testToken("1") + "\n\n" + testToken("2") + "\n",
+			want: []WorkerToken{
+				{Label: "tokens.txt:1", Token: testToken("1")},
+				{Label: "tokens.txt:3", Token: testToken("2")},
+			},
+		},
+		{
+			name: "comments",
+			data: "# workers\n" + testToken("1") + " # main worker\n   # indented comment\n",
+			want: []WorkerToken{{Label: "tokens.txt:2", Token: testToken("1")}},
+		},
+		{
+			name: "labels",
+			data: "alpha = " + testToken("1") + "\n@beta_2.x=" + testToken("2") + "\n",
+			want: []WorkerToken{
+				{Label: "alpha", Token: testToken("1")},
+				{Label: "@beta_2.x", Token: testToken("2")},
+			},
+		},
+		{
+			name: "invalid tokens",
+			data: "123:short\nnot-a-token\nbad=abc:" + testToken("1")[2:] + "\n" + testToken("4") + "\n",
+			want: []WorkerToken{{Label: "tokens.txt:4", Token: testToken("4")}},
+			errs: []string{`:1: invalid bot token "123:***"`, `:2: invalid bot token "not-a-***"`, `:3: invalid bot token "abc:***"`},
+		},
+		{
+			name: "invalid labels",
+			data: "my worker=" + testToken("1") + "\n=" + testToken("2") + "\nok=" + testToken("3") + "\n",
+			want: []WorkerToken{{Label: "ok", Token: testToken("3")}},
+			errs: []string{`:1: invalid label "my worker"`, `:2: invalid label ""`},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tokens, err := readTokenFile(writeFile(t, "tokens.txt", test.data))
+			if got, want := tokenLabels(tokens), tokenLabels(test.want); got != want {
+				t.Errorf("got tokens %s, want %s", got, want)
+			}
+			if len(test.errs) == 0 && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, want := range test.errs {
+				if err == nil || !strings.Contains(err.Error(), want) {
+					t.Errorf("expected %s in error %v", want, err)
+				}
+			}
+			if err != nil && strings.Contains(err.Error(), "AAbbCC") {
+				t.Errorf("error leaks a token secret: %v", err)
+			}
+		})
+	}
+}
+
+func TestEnvTokens(t *testing.T) {
+	tokens, err := envTokens(map[string]string{
+		"MULTI_TOKEN10": testToken("10"),
+		"MULTI_TOKEN2":  " " + testToken("2") + " ",
+		"MULTI_TOKEN1":  testToken("1"),
+		"MULTI_TOKEN3":  "invalid",
+		"BOT_TOKEN":     testToken("99"),
+	})
+	want := "MULTI_TOKEN1=" + testToken("1") + ",MULTI_TOKEN2=" + testToken("2") + ",MULTI_TOKEN10=" + testToken("10")
+	if got := tokenLabels(tokens); got != want {
+		t.Errorf("got tokens %s, want %s", got, want)
+	}
+	if err == nil || !strings.Contains(err.Error(), `MULTI_TOKEN3: invalid bot token "invali***"`) {
+		t.Errorf("expected an error for MULTI_TOKEN3, got %v", err)
+	}
+}
+
+func TestCollectTokens(t *testing.T) {
+	tests := []struct {
+		name      string
+		fileEnv   map[string]string
+		process   map[string]string
+		tokenFile string
+		want      string
+		errs      []string
+	}{
+		{
+			name:      "file token duplicates an env token",
+			fileEnv:   map[string]string{"MULTI_TOKEN1": testToken("1")},
+			tokenFile: "first=" + testToken("1") + "\nsecond=" + testToken("2") + "\n",
+			want:      "MULTI_TOKEN1=" + testToken("1") + ",second=" + testToken("2"),
+		},
+		{
+			name:      "duplicated token in the file",
+			tokenFile: testToken("1") + "\n" + testToken("1") + "\n",
+			want:      "tokens.txt:1=" + testToken("1"),
+		},
+		{
+			name:      "label used twice",
+			tokenFile: "worker=" + testToken("1") + "\nworker=" + testToken("2") + "\n",
+			want:      "worker=" + testToken("1"),
+			errs:      []string{"worker: label used by another token"},
+		},
+		{
+			name:      "file label clashes with an env variable",
+			fileEnv:   map[string]string{"MULTI_TOKEN1": testToken("1")},
+			tokenFile: "MULTI_TOKEN1=" + testToken("2") + "\n",
+			want:      "MULTI_TOKEN1=" + testToken("1"),
+			errs:      []string{"MULTI_TOKEN1: label used by another token"},
+		},
+		{
+			name:    "environment wins over fsb.env",
+			fileEnv: map[string]string{"MULTI_TOKEN1": testToken("1"), "MULTI_TOKEN2": testToken("2")},
+			process: map[string]string{"MULTI_TOKEN1": testToken("3")},
+			want:    "MULTI_TOKEN1=" + testToken("3") + ",MULTI_TOKEN2=" + testToken("2"),
+		},
+		{
+			name:      "invalid entries are reported",
+			fileEnv:   map[string]string{"MULTI_TOKEN1": "nope"},
+			tokenFile: "bad label=" + testToken("1") + "\n" + testToken("2") + "\n",
+			want:      "tokens.txt:2=" + testToken("2"),
+			errs:      []string{"MULTI_TOKEN1: invalid bot token", "multi token file:", `invalid label "bad label"`},
+		},
+	}
+	oldFile, oldProcess := ValueOf.MultiTokenFile, processTokens
+	t.Cleanup(func() { ValueOf.MultiTokenFile, processTokens = oldFile, oldProcess })
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			processTokens = test.process
+			ValueOf.MultiTokenFile = ""
+			if test.tokenFile != "" {
+				ValueOf.MultiTokenFile = writeFile(t, "tokens.txt", test.tokenFile)
+			}
+			tokens, err := collectTokens(zap.NewNop(), test.fileEnv)
+			if got := tokenLabels(tokens); got != test.want {
+				t.Errorf("got tokens %s, want %s", got, test.want)
+			}
+			if len(test.errs) == 0 && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, want := range test.errs {
+				if err == nil || !strings.Contains(err.Error(), want) {
+					t.Errorf("expected %s in error %v", want, err)
+				}
+			}
+		})
+	}
+}
+
+// The sessions commands find the multi token txt file set in the config file.
+func TestLoadTokensConfigFile(t *testing.T) {
+	unsetEnv(t)
+	t.Setenv("FSB_CONFIG", "")
+	t.Chdir(t.TempDir())
+	oldFile := ValueOf.MultiTokenFile
+	t.Cleanup(func() { ValueOf.MultiTokenFile = oldFile })
+	if err := os.WriteFile("tokens.txt", []byte("worker="+testToken("1")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("fsb.yaml", []byte("workers:\n  tokens_file: tokens.txt\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := LoadTokens(zap.NewNop(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tokenLabels(tokens), "worker="+testToken("1"); got != want {
+		t.Errorf("got tokens %s, want %s", got, want)
+	}
+
+	// fsb.env wins over the config file
+	if err := os.WriteFile("fsb.env", []byte("MULTI_TOKEN_TXT_FILE=missing.txt\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadTokens(zap.NewNop(), ""); err == nil || !strings.Contains(err.Error(), "missing.txt") {
+		t.Errorf("expected an error for the tokens file of fsb.env, got %v", err)
+	}
+	os.Remove("fsb.env")
+
+	if _, err := LoadTokens(zap.NewNop(), "other.yaml"); err == nil {
+		t.Error("expected an error for a missing --config file")
+	}
+	if err := os.WriteFile("tokens.txt", []byte("bad label="+testToken("1")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadTokens(zap.NewNop(), ""); err == nil {
+		t.Error("expected an error for an invalid tokens file")
+	}
+}
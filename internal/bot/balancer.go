@@ -0,0 +1,265 @@
+package bot
+
+import (
+	"EverythingSuckz/fsb/config"
+	"context"
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+	"go.uber.org/zap"
+)
+
+// Worker selection strategies for streams, see config WORKER_STRATEGY.
+const (
+	StrategyRoundRobin       = "round-robin"
+	StrategyLeastConnections = "least-connections"
+	StrategyWeighted         = "weighted"
+	StrategySticky           = "sticky"
+)
+
+// in-flight UploadGetFile bytes are counted as one stream per chunk
+const loadChunkSize = 1024 * 1024
+
+// a sticky worker is skipped once it has this many times the average load
+const stickyLoadFactor = 1.25
+
+// workerLoad tracks the streams and uploads served by a worker and the
+// UploadGetFile bytes it is currently fetching. It is a telegram.Middleware.
+type workerLoad struct {
+	streams       atomic.Int64
+	uploads       atomic.Int64
+	inflightBytes atomic.Int64
+}
+
+func (l *workerLoad) Handle(next tg.Invoker) telegram.InvokeFunc {
+	return func(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+		if req, ok := input.(*tg.UploadGetFileRequest); ok {
+			l.inflightBytes.Add(int64(req.Limit))
+			defer l.inflightBytes.Add(-int64(req.Limit))
+		}
+		return next.Invoke(ctx, input, output)
+	}
+}
+
+// StreamStarted marks a new stream served by the worker. Every call must be
+// paired with StreamFinished.
+func (w *Worker) StreamStarted() {
+	if w.load != nil {
+		w.load.streams.Add(1)
+	}
+}
+
+func (w *Worker) StreamFinished() {
+	if w.load != nil {
+		w.load.streams.Add(-1)
+	}
+}
+
+// UploadStarted marks a new upload through the worker. Every call must be
+// paired with UploadFinished.
+func (w *Worker) UploadStarted() {
+	if w.load != nil {
+		w.load.uploads.Add(1)
+	}
+}
+
+func (w *Worker) UploadFinished() {
+	if w.load != nil {
+		w.load.uploads.Add(-1)
+	}
+}
+
+// busy reports whether the worker still serves a stream or an upload.
+func (w *Worker) busy() bool {
+	if w.load == nil {
+		return false
+	}
+	return w.load.streams.Load() > 0 || w.load.uploads.Load() > 0 || w.load.inflightBytes.Load() > 0
+}
+
+// ActiveStreams returns the number of streams currently served by the worker.
+func (w *Worker) ActiveStreams() int64 {
+	if w.load == nil {
+		return 0
+	}
+	return w.load.streams.Load()
+}
+
+// InflightBytes returns the bytes of pending UploadGetFile calls of the worker.
+func (w *Worker) InflightBytes() int64 {
+	if w.load == nil {
+		return 0
+	}
+	return w.load.inflightBytes.Load()
+}
+
+func (w *Worker) loadScore() float64 {
+	return float64(w.ActiveStreams()) + float64(w.InflightBytes())/loadChunkSize
+}
+
+var (
+	weightsOnce sync.Once
+	weights     map[string]float64
+)
+
+// workerWeight returns the weight configured for a worker in WORKER_WEIGHTS
+// (username=weight pairs separated by commas), 1 by default.
+func workerWeight(w *Worker) float64 {
+	weightsOnce.Do(func() {
+		weights = parseWorkerWeights(config.ValueOf.WorkerWeights, Workers.log)
+	})
+	if w.Self != nil {
+		if weight, ok := weights[strings.ToLower(w.Self.Username)]; ok {
+			return weight
+		}
+	}
+	return 1
+}
+
+// parseWorkerWeights parses WORKER_WEIGHTS, logging the entries it ignores.
+func parseWorkerWeights(list string, log *zap.Logger) map[string]float64 {
+	parsed := make(map[string]float64)
+	for _, pair := range strings.Split(list, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		name = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(name), "@"))
+		if !ok || name == "" {
+			log.Warn("Ignoring WORKER_WEIGHTS entry, expected username=weight", zap.String("entry", pair))
+			continue
+		}
+		weight, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || weight <= 0 || math.IsInf(weight, 0) {
+			log.Warn("Ignoring WORKER_WEIGHTS entry, the weight must be a positive number", zap.String("entry", pair))
+			continue
+		}
+		parsed[name] = weight
+	}
+	return parsed
+}
+
+// StartStream picks the worker for a stream of the given log channel message
+// and marks the stream as started on it, so that a reload can't stop the
+// worker in between. The stream must be ended with StreamFinished.
+func StartStream(messageID int) *Worker {
+	selectionMut.RLock()
+	defer selectionMut.RUnlock()
+	worker := GetWorkerForFile(messageID)
+	worker.StreamStarted()
+	return worker
+}
+
+// GetWorkerForFile returns the worker that should serve a stream of the
+// given log channel message, according to the configured strategy.
+func GetWorkerForFile(messageID int) *Worker {
+	switch config.ValueOf.WorkerStrategy {
+	case StrategyLeastConnections:
+		return getLeastLoadedWorker(false)
+	case StrategyWeighted:
+		return getLeastLoadedWorker(true)
+	case StrategySticky:
+		return getStickyWorker(messageID)
+	}
+	return GetNextWorker()
+}
+
+// candidates returns the healthy workers starting after the last used one,
+// or all of them if none is healthy.
+func (w *BotWorkers) candidates() []*Worker {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	total := len(w.Bots)
+	healthy := make([]*Worker, 0, total)
+	all := make([]*Worker, 0, total)
+	for i := 1; i <= total; i++ {
+		worker := w.Bots[(w.index+i)%total]
+		all = append(all, worker)
+		if worker.Healthy() {
+			healthy = append(healthy, worker)
+		}
+	}
+	if len(healthy) == 0 {
+		return all
+	}
+	return healthy
+}
+
+func (w *BotWorkers) markUsed(worker *Worker) {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	for i, bot := range w.Bots {
+		if bot == worker {
+			w.index = i
+			return
+		}
+	}
+}
+
+func getLeastLoadedWorker(weighted bool) *Worker {
+	var selected *Worker
+	best := math.Inf(1)
+	// candidates start after the last used worker, so ties rotate
+	for _, worker := range Workers.candidates() {
+		score := worker.loadScore()
+		if weighted {
+			score /= workerWeight(worker)
+		}
+		if score < best {
+			best = score
+			selected = worker
+		}
+	}
+	Workers.markUsed(selected)
+	Workers.log.Sugar().Debugf("Using worker %s (load %.2f)", selected.Label, best)
+	return selected
+}
+
+// getStickyWorker uses rendezvous hashing so all readers of a file land on
+// the same worker (and hit its cache), unless that worker is carrying much
+// more than its share of streams.
+func getStickyWorker(messageID int) *Worker {
+	candidates := Workers.candidates()
+	var total float64
+	scores := make(map[*Worker]uint64, len(candidates))
+	for _, worker := range candidates {
+		total += worker.loadScore()
+		scores[worker] = rendezvousScore(messageID, worker)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return scores[candidates[i]] > scores[candidates[j]]
+	})
+	limit := math.Ceil(total/float64(len(candidates))*stickyLoadFactor) + 1
+	selected := candidates[0]
+	for _, worker := range candidates {
+		if worker.loadScore() < limit {
+			selected = worker
+			break
+		}
+	}
+	Workers.log.Sugar().Debugf("Using worker %s for message %d", selected.Label, messageID)
+	return selected
+}
+
+func rendezvousScore(messageID int, w *Worker) uint64 {
+	var buf [16]byte
+	binary.LittleEndian.PutUint64(buf[:8], uint64(messageID))
+	id := int64(w.ID)
+	if w.Self != nil {
+		id = w.Self.ID
+	}
+	binary.LittleEndian.PutUint64(buf[8:], uint64(id))
+	h := fnv.New64a()
+	h.Write(buf[:])
+	return h.Sum64()
+}
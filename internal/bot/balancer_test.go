@@ -0,0 +1,132 @@
+package bot
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"EverythingSuckz/fsb/config"
+
+	"github.com/gotd/td/tg"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// useTestWorkers replaces the workers with n test workers for the test and
+// the given strategy.
+func useTestWorkers(t *testing.T, strategy string, n int) []*Worker {
+	bots := make([]*Worker, n)
+	for i := range bots {
+		bots[i] = newTestWorker(i + 1)
+		bots[i].Self = &tg.User{ID: int64(1000 + i), Username: "bot" + string(rune('a'+i))}
+	}
+	oldWorkers, oldStrategy := Workers, config.ValueOf.WorkerStrategy
+	Workers = &BotWorkers{Bots: bots, log: zap.NewNop()}
+	config.ValueOf.WorkerStrategy = strategy
+	t.Cleanup(func() { Workers, config.ValueOf.WorkerStrategy = oldWorkers, oldStrategy })
+	return bots
+}
+
+func setStreams(w *Worker, streams int64) {
+	w.load.streams.Store(streams)
+}
+
+func TestRoundRobin(t *testing.T) {
+	bots := useTestWorkers(t, StrategyRoundRobin, 3)
+	bots[1].health.floodUntil = time.Now().Add(time.Minute)
+	var got []int
+	for i := 0; i < 4; i++ {
+		got = append(got, GetWorkerForFile(i).ID)
+	}
+	want := []int{3, 1, 3, 1}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got workers %v, want %v", got, want)
+		}
+	}
+}
+
+func TestLeastConnections(t *testing.T) {
+	bots := useTestWorkers(t, StrategyLeastConnections, 3)
+	setStreams(bots[0], 2)
+	setStreams(bots[2], 1)
+	if got := GetWorkerForFile(1); got != bots[1] {
+		t.Fatalf("expected the idle worker %d, got %d", bots[1].ID, got.ID)
+	}
+	// in-flight bytes count as load too
+	bots[1].load.inflightBytes.Store(3 * loadChunkSize)
+	if got := GetWorkerForFile(1); got != bots[2] {
+		t.Fatalf("expected the least loaded worker %d, got %d", bots[2].ID, got.ID)
+	}
+	// ties rotate instead of always picking the first worker
+	setStreams(bots[0], 0)
+	setStreams(bots[2], 0)
+	bots[1].load.inflightBytes.Store(0)
+	seen := map[int]bool{}
+	for i := 0; i < 3; i++ {
+		seen[GetWorkerForFile(1).ID] = true
+	}
+	if len(seen) != 3 {
+		t.Errorf("idle workers weren't rotated, used %v", seen)
+	}
+}
+
+func TestWeighted(t *testing.T) {
+	bots := useTestWorkers(t, StrategyWeighted, 2)
+	oldWeights := config.ValueOf.WorkerWeights
+	config.ValueOf.WorkerWeights = "@BOTA=3"
+	weightsOnce = sync.Once{}
+	t.Cleanup(func() {
+		config.ValueOf.WorkerWeights = oldWeights
+		weightsOnce = sync.Once{}
+	})
+	setStreams(bots[0], 2)
+	setStreams(bots[1], 1)
+	if got := GetWorkerForFile(1); got != bots[0] {
+		t.Fatalf("expected the heavier worker %d, got %d", bots[0].ID, got.ID)
+	}
+	setStreams(bots[0], 4)
+	if got := GetWorkerForFile(1); got != bots[1] {
+		t.Fatalf("expected worker %d once the heavier one is over its share, got %d", bots[1].ID, got.ID)
+	}
+}
+
+func TestSticky(t *testing.T) {
+	bots := useTestWorkers(t, StrategySticky, 4)
+	first := GetWorkerForFile(42)
+	for i := 0; i < 10; i++ {
+		// other requests move the round-robin index in between
+		GetNextWorker()
+		if got := GetWorkerForFile(42); got != first {
+			t.Fatalf("message 42 moved from worker %d to %d", first.ID, got.ID)
+		}
+	}
+	used := map[int]bool{}
+	for messageID := 0; messageID < 100; messageID++ {
+		used[GetWorkerForFile(messageID).ID] = true
+	}
+	if len(used) != len(bots) {
+		t.Errorf("messages only went to workers %v", used)
+	}
+
+	setStreams(first, 10)
+	if got := GetWorkerForFile(42); got == first {
+		t.Error("overloaded sticky worker was still used")
+	}
+	setStreams(first, 0)
+	first.health.floodUntil = time.Now().Add(time.Minute)
+	if got := GetWorkerForFile(42); got == first {
+		t.Error("unhealthy sticky worker was still used")
+	}
+}
+
+func TestParseWorkerWeights(t *testing.T) {
+	core, logs := observer.New(zap.WarnLevel)
+	weights := parseWorkerWeights(" @BotA=2, botb = 0.5,,botc,=3,botd=abc,bote=-1,botf=0", zap.New(core))
+	if len(weights) != 2 || weights["bota"] != 2 || weights["botb"] != 0.5 {
+		t.Errorf("got weights %v", weights)
+	}
+	if logs.Len() != 5 {
+		t.Errorf("expected 5 warnings for the malformed entries, got %d", logs.Len())
+	}
+}
@@ -3,8 +3,9 @@ package bot
 import (
 	"EverythingSuckz/fsb/config"
 	"EverythingSuckz/fsb/internal/commands"
-	"EverythingSuckz/fsb/internal/utils"
+	"EverythingSuckz/fsb/internal/tracing"
 	"context"
+	"sync"
 	"time"
 
 	"go.uber.org/zap"
@@ -12,57 +13,76 @@ import (
 	"github.com/celestix/gotgproto"
 	"github.com/celestix/gotgproto/sessionMaker"
 	"github.com/glebarez/sqlite"
-	"github.com/gotd/td/telegram/dcs"
+	"github.com/gotd/td/telegram"
 )
 
-var Bot *gotgproto.Client
+var (
+	botMut sync.RWMutex
+	bot    *gotgproto.Client
+)
+
+var (
+	mainHealth     = newWorkerHealth()
+	mainLoad       = &workerLoad{}
+	mainClientOpts *gotgproto.ClientOpts
+)
+
+// Bot returns the client of the main bot, nil until it is started. It
+// changes when the default worker reconnects.
+func Bot() *gotgproto.Client {
+	botMut.RLock()
+	defer botMut.RUnlock()
+	return bot
+}
+
+func setBot(client *gotgproto.Client) {
+	botMut.Lock()
+	defer botMut.Unlock()
+	bot = client
+}
 
 func StartClient(log *zap.Logger) (*gotgproto.Client, error) {
-	// 验证代理配置
-	if config.ValueOf.TelegramProxy != "" {
-		if err := utils.ValidateProxyURL(config.ValueOf.TelegramProxy); err != nil {
-			log.Error("Invalid Telegram proxy configuration", zap.Error(err))
-			return nil, err
-		}
-		log.Info("Using Telegram proxy", zap.String("proxy", config.ValueOf.TelegramProxy))
+	client, opts, err := startMainClient(log)
+	if err != nil {
+		return nil, err
 	}
+	log.Info("Client started", zap.String("username", client.Self.Username))
+	setBot(client)
+	mainClientOpts = opts
+	return client, nil
+}
 
+// startMainClient logs the main bot in and registers the commands on its
+// dispatcher.
+func startMainClient(log *zap.Logger) (*gotgproto.Client, *gotgproto.ClientOpts, error) {
 	// 创建自定义Resolver（支持代理）
-	var resolver dcs.Resolver
-	if config.ValueOf.TelegramProxy != "" {
-		dialFunc, err := utils.CreateSOCKS5Dialer(config.ValueOf.TelegramProxy)
-		if err != nil {
-			log.Error("Failed to create SOCKS5 dialer", zap.Error(err))
-			return nil, err
-		}
-		
-		// 使用自定义Dialer创建Plain resolver
-		resolver = dcs.Plain(dcs.PlainOptions{
-			Dial: dcs.DialFunc(dialFunc),
-		})
-		log.Info("✓ SOCKS5代理已配置", zap.String("proxy", config.ValueOf.TelegramProxy))
-	} else {
-		resolver = dcs.DefaultResolver()
+	resolver, err := newResolver(log, telegramProxies())
+	if err != nil {
+		log.Error("Invalid Telegram proxy configuration", zap.Error(err))
+		return nil, nil, err
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
+	// buffered so that the goroutine doesn't leak after a timeout
 	resultChan := make(chan struct {
 		client *gotgproto.Client
 		err    error
-	})
+	}, 1)
+	opts := &gotgproto.ClientOpts{
+		Session: sessionMaker.SqlSession(
+			sqlite.Open("fsb.session"),
+		),
+		DisableCopyright: true,
+		Resolver:         resolver, // 使用自定义Resolver
+		Middlewares:      []telegram.Middleware{floodWaitPassthrough("default"), mainHealth, mainLoad, getFileMetrics("default"), tracing.Middleware("default")},
+	}
 	go func(ctx context.Context) {
 		client, err := gotgproto.NewClient(
 			int(config.ValueOf.ApiID),
 			config.ValueOf.ApiHash,
 			gotgproto.ClientTypeBot(config.ValueOf.BotToken),
-			&gotgproto.ClientOpts{
-				Session: sessionMaker.SqlSession(
-					sqlite.Open("fsb.session"),
-				),
-				DisableCopyright: true,
-				Resolver:         resolver, // 使用自定义Resolver
-			},
+			opts,
 		)
 		resultChan <- struct {
 			client *gotgproto.Client
@@ -72,14 +92,12 @@ func StartClient(log *zap.Logger) (*gotgproto.Client, error) {
 
 	select {
 	case <-ctx.Done():
-		return nil, ctx.Err()
+		return nil, nil, ctx.Err()
 	case result := <-resultChan:
 		if result.err != nil {
-			return nil, result.err
+			return nil, nil, result.err
 		}
 		commands.Load(log, result.client.Dispatcher)
-		log.Info("Client started", zap.String("username", result.client.Self.Username))
-		Bot = result.client
-		return result.client, nil
+		return result.client, opts, nil
 	}
 }
@@ -0,0 +1,437 @@
+package bot
+
+import (
+	"EverythingSuckz/fsb/internal/utils"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/celestix/gotgproto"
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+	"go.uber.org/zap"
+)
+
+const (
+	healthCheckInterval = 30 * time.Second
+	healthPingTimeout   = 10 * time.Second
+	// a worker is ejected for healthEjectDuration when at least half of its
+	// last healthWindowSize calls failed (given enough samples).
+	healthWindowSize    = 20
+	healthMinSamples    = 10
+	healthMaxErrorRate  = 0.5
+	healthEjectDuration = 30 * time.Second
+	// failed health checks before a reconnect is attempted
+	healthMaxPingFailures = 3
+	reconnectTimeout      = 30 * time.Second
+	reconnectMinBackoff   = 5 * time.Second
+	reconnectMaxBackoff   = 5 * time.Minute
+)
+
+// WorkerState is the health state of a worker as reported by /workers/health.
+type WorkerState string
+
+const (
+	WorkerHealthy      WorkerState = "healthy"
+	WorkerEjected      WorkerState = "ejected"
+	WorkerFloodWait    WorkerState = "flood_wait"
+	WorkerDisconnected WorkerState = "disconnected"
+	WorkerReconnecting WorkerState = "reconnecting"
+	WorkerBanned       WorkerState = "banned"
+)
+
+// errors meaning the bot token or session is no longer usable
+var bannedErrors = []string{
+	"AUTH_KEY_UNREGISTERED",
+	"AUTH_KEY_INVALID",
+	"SESSION_REVOKED",
+	"USER_DEACTIVATED",
+	"USER_DEACTIVATED_BAN",
+	"ACCESS_TOKEN_EXPIRED",
+	"ACCESS_TOKEN_INVALID",
+}
+
+// WorkerHealth is a point in time view of a worker's health.
+type WorkerHealth struct {
+	ID                int         `json:"id"`
+	Label             string      `json:"label"`
+	Proxy             string      `json:"proxy,omitempty"`
+	Username          string      `json:"username"`
+	State             WorkerState `json:"state"`
+	Healthy           bool        `json:"healthy"`
+	RecentRequests    int         `json:"recentRequests"`
+	ErrorRate         float64     `json:"errorRate"`
+	LatencyMs         float64     `json:"latencyMs"`
+	FloodWaitUntil    *time.Time  `json:"floodWaitUntil,omitempty"`
+	EjectedUntil      *time.Time  `json:"ejectedUntil,omitempty"`
+	LastError         string      `json:"lastError,omitempty"`
+	LastCheck         *time.Time  `json:"lastCheck,omitempty"`
+	ReconnectAttempts int         `json:"reconnectAttempts"`
+	ActiveStreams     int64       `json:"activeStreams"`
+	InflightBytes     int64       `json:"inflightBytes"`
+}
+
+// workerHealth tracks the recent behaviour of a worker's client. It is also
+// a telegram.Middleware recording the outcome of every RPC call.
+type workerHealth struct {
+	mut           sync.Mutex
+	outcomes      []bool // true for failed calls, oldest first
+	latency       time.Duration
+	floodUntil    time.Time
+	ejectedUntil  time.Time
+	banned        bool
+	disconnected  bool
+	reconnecting  bool
+	pingFailures  int
+	reconnects    int
+	nextReconnect time.Time
+	lastError     string
+	lastCheck     time.Time
+}
+
+func newWorkerHealth() *workerHealth {
+	return &workerHealth{outcomes: make([]bool, 0, healthWindowSize)}
+}
+
+func (h *workerHealth) Handle(next tg.Invoker) telegram.InvokeFunc {
+	return func(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+		start := time.Now()
+		err := next.Invoke(ctx, input, output)
+		h.record(time.Since(start), err)
+		return err
+	}
+}
+
+func (h *workerHealth) record(latency time.Duration, err error) {
+	// the caller went away, that says nothing about the worker
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	now := time.Now()
+	if d, ok := tgerr.AsFloodWait(err); ok {
+		if until := now.Add(d); until.After(h.floodUntil) {
+			h.floodUntil = until
+		}
+	}
+	if err != nil && tgerr.Is(err, bannedErrors...) {
+		h.banned = true
+	}
+	failed := workerFailure(err)
+	switch {
+	case failed:
+		h.lastError = err.Error()
+	case err != nil:
+		// errors caused by the request, like an expired file reference or an
+		// invalid offset, say nothing about the worker
+		return
+	default:
+		h.observeLatency(latency)
+	}
+	if len(h.outcomes) == healthWindowSize {
+		h.outcomes = h.outcomes[1:]
+	}
+	h.outcomes = append(h.outcomes, failed)
+	if len(h.outcomes) >= healthMinSamples && h.errorRate() >= healthMaxErrorRate {
+		h.ejectedUntil = now.Add(healthEjectDuration)
+		// start over once the worker is back in rotation
+		h.outcomes = h.outcomes[:0]
+	}
+}
+
+// workerFailure reports whether err is the worker's fault: transport errors,
+// Telegram server errors and auth errors. FLOOD_WAIT is tracked on its own.
+func workerFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	rpcErr, ok := tgerr.As(err)
+	if !ok {
+		return true
+	}
+	return rpcErr.Code >= 500 || rpcErr.Code == 401 || rpcErr.IsOneOf(bannedErrors...)
+}
+
+func (h *workerHealth) observeLatency(latency time.Duration) {
+	if h.latency == 0 {
+		h.latency = latency
+		return
+	}
+	// exponentially weighted moving average
+	h.latency = (h.latency*4 + latency) / 5
+}
+
+func (h *workerHealth) errorRate() float64 {
+	if len(h.outcomes) == 0 {
+		return 0
+	}
+	failed := 0
+	for _, outcome := range h.outcomes {
+		if outcome {
+			failed++
+		}
+	}
+	return float64(failed) / float64(len(h.outcomes))
+}
+
+func (h *workerHealth) state(now time.Time) WorkerState {
+	switch {
+	case h.banned:
+		return WorkerBanned
+	case h.reconnecting:
+		return WorkerReconnecting
+	case h.disconnected:
+		return WorkerDisconnected
+	case now.Before(h.floodUntil):
+		return WorkerFloodWait
+	case now.Before(h.ejectedUntil):
+		return WorkerEjected
+	}
+	return WorkerHealthy
+}
+
+func (h *workerHealth) healthy() bool {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	return h.state(time.Now()) == WorkerHealthy
+}
+
+// checked records the result of a health check ping and reports whether a
+// reconnect should be attempted.
+func (h *workerHealth) checked(latency time.Duration, err error) bool {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	now := time.Now()
+	h.lastCheck = now
+	if err == nil {
+		h.pingFailures = 0
+		h.disconnected = false
+		h.reconnects = 0
+		h.observeLatency(latency)
+		return false
+	}
+	h.lastError = err.Error()
+	h.pingFailures++
+	if h.pingFailures < healthMaxPingFailures {
+		return false
+	}
+	h.disconnected = true
+	if h.banned || h.reconnecting || now.Before(h.nextReconnect) {
+		return false
+	}
+	h.reconnecting = true
+	h.reconnects++
+	return true
+}
+
+// reconnected records the outcome of a reconnect attempt.
+func (h *workerHealth) reconnected(err error) {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	h.reconnecting = false
+	if err == nil {
+		h.disconnected = false
+		h.pingFailures = 0
+		h.reconnects = 0
+		h.nextReconnect = time.Time{}
+		return
+	}
+	h.lastError = err.Error()
+	backoff := reconnectMinBackoff << min(h.reconnects-1, 10)
+	if backoff > reconnectMaxBackoff {
+		backoff = reconnectMaxBackoff
+	}
+	h.nextReconnect = time.Now().Add(backoff)
+}
+
+func (h *workerHealth) snapshot(w *Worker) WorkerHealth {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	now := time.Now()
+	state := h.state(now)
+	info := WorkerHealth{
+		ID:                w.ID,
+		Label:             w.Label,
+		Proxy:             utils.RedactProxyURL(w.proxy),
+		State:             state,
+		Healthy:           state == WorkerHealthy,
+		RecentRequests:    len(h.outcomes),
+		ErrorRate:         h.errorRate(),
+		LatencyMs:         float64(h.latency) / float64(time.Millisecond),
+		LastError:         h.lastError,
+		ReconnectAttempts: h.reconnects,
+		ActiveStreams:     w.ActiveStreams(),
+		InflightBytes:     w.InflightBytes(),
+	}
+	if w.Self != nil {
+		info.Username = w.Self.Username
+	}
+	if now.Before(h.floodUntil) {
+		until := h.floodUntil
+		info.FloodWaitUntil = &until
+	}
+	if now.Before(h.ejectedUntil) {
+		until := h.ejectedUntil
+		info.EjectedUntil = &until
+	}
+	if !h.lastCheck.IsZero() {
+		lastCheck := h.lastCheck
+		info.LastCheck = &lastCheck
+	}
+	return info
+}
+
+// floodWaitUntil returns the end of the worker's current FLOOD_WAIT.
+func (w *Worker) floodWaitUntil() time.Time {
+	if w.health == nil {
+		return time.Time{}
+	}
+	w.health.mut.Lock()
+	defer w.health.mut.Unlock()
+	return w.health.floodUntil
+}
+
+// Healthy reports whether the worker should receive new requests.
+func (w *Worker) Healthy() bool {
+	if w.health == nil {
+		return true
+	}
+	return w.health.healthy()
+}
+
+// Health returns the current health of the worker.
+func (w *Worker) Health() WorkerHealth {
+	if w.health == nil {
+		return (&workerHealth{}).snapshot(w)
+	}
+	return w.health.snapshot(w)
+}
+
+func (w *Worker) checkHealth() {
+	ctx, cancel := context.WithTimeout(context.Background(), healthPingTimeout)
+	defer cancel()
+	start := time.Now()
+	err := w.Client().Ping(ctx)
+	if err != nil {
+		w.log.Warn("Health check failed", zap.String("worker", w.Label), zap.Error(err))
+	}
+	if w.health.checked(time.Since(start), err) {
+		go w.reconnect()
+	}
+}
+
+// stopClient stops a client that is no longer used and closes its session
+// database.
+var stopClient = func(client *gotgproto.Client) {
+	client.Stop()
+	closeSession(client)
+}
+
+// reconnect starts a new client for the worker and swaps it in. Requests
+// that got the old client keep using it, it is stopped once the worker is
+// idle.
+func (w *Worker) reconnect() {
+	if shuttingDown.Load() {
+		return
+	}
+	w.log.Info("Reconnecting worker", zap.String("worker", w.Label))
+	type result struct {
+		client *gotgproto.Client
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		client, err := w.newClient()
+		done <- result{client, err}
+	}()
+	var client *gotgproto.Client
+	var err error
+	select {
+	case r := <-done:
+		client, err = r.client, r.err
+	case <-time.After(reconnectTimeout):
+		go func() {
+			if r := <-done; r.client != nil {
+				stopClient(r.client)
+			}
+		}()
+		err = fmt.Errorf("timed out after %s", reconnectTimeout)
+	}
+	if err == nil {
+		var old *gotgproto.Client
+		if old, err = w.swapClient(client); err != nil {
+			stopClient(client)
+		} else {
+			go w.retireClient(old)
+		}
+	}
+	if err != nil {
+		w.log.Error("Failed to reconnect worker", zap.String("worker", w.Label), zap.Error(err))
+	} else {
+		w.log.Info("Worker reconnected", zap.String("worker", w.Label))
+	}
+	w.health.reconnected(err)
+}
+
+// swapClient replaces the worker's client and returns the old one. It fails
+// once Shutdown started, which then stops the current client itself.
+func (w *Worker) swapClient(client *gotgproto.Client) (*gotgproto.Client, error) {
+	w.clientMut.Lock()
+	defer w.clientMut.Unlock()
+	if shuttingDown.Load() {
+		return nil, errors.New("shutting down")
+	}
+	old := w.client
+	w.client = client
+	if w.token == "" {
+		setBot(client)
+	}
+	return old, nil
+}
+
+// retireClient stops the old client of a reconnected worker once the
+// streams and uploads that may still use it are done.
+func (w *Worker) retireClient(client *gotgproto.Client) {
+	if client == nil {
+		return
+	}
+	w.waitIdle(drainTimeout)
+	stopClient(client)
+}
+
+// StartHealthMonitor periodically pings every worker and reconnects the ones
+// that stopped responding.
+func StartHealthMonitor(log *zap.Logger) {
+	log = log.Named("Health")
+	log.Info("Starting worker health monitor", zap.Duration("interval", healthCheckInterval))
+	go func() {
+		ticker := time.NewTicker(healthCheckInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if shuttingDown.Load() {
+				return
+			}
+			for _, worker := range Workers.snapshot() {
+				if worker.health == nil || worker.newClient == nil {
+					continue
+				}
+				go worker.checkHealth()
+			}
+		}
+	}()
+}
+
+// GetWorkersHealth returns the health of every worker.
+func GetWorkersHealth() []WorkerHealth {
+	workers := Workers.snapshot()
+	health := make([]WorkerHealth, 0, len(workers))
+	for _, worker := range workers {
+		health = append(health, worker.Health())
+	}
+	return health
+}
@@ -0,0 +1,205 @@
+package bot
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/celestix/gotgproto"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tgerr"
+	"go.uber.org/zap"
+)
+
+func TestWorkerHealth_FloodWait(t *testing.T) {
+	h := newWorkerHealth()
+	h.record(time.Millisecond, tgerr.New(420, "FLOOD_WAIT_30"))
+	if h.healthy() {
+		t.Fatal("worker in flood wait should not be healthy")
+	}
+	if state := h.state(time.Now()); state != WorkerFloodWait {
+		t.Errorf("expected state %s, got %s", WorkerFloodWait, state)
+	}
+	if state := h.state(time.Now().Add(31 * time.Second)); state != WorkerHealthy {
+		t.Errorf("expected state %s after the flood wait, got %s", WorkerHealthy, state)
+	}
+}
+
+func TestWorkerHealth_Ejection(t *testing.T) {
+	h := newWorkerHealth()
+	for i := 0; i < healthMinSamples-1; i++ {
+		h.record(time.Millisecond, errors.New("rpc error"))
+	}
+	if !h.healthy() {
+		t.Fatal("worker should stay healthy until there are enough samples")
+	}
+	h.record(time.Millisecond, errors.New("rpc error"))
+	if state := h.state(time.Now()); state != WorkerEjected {
+		t.Fatalf("expected state %s, got %s", WorkerEjected, state)
+	}
+	if state := h.state(time.Now().Add(healthEjectDuration + time.Second)); state != WorkerHealthy {
+		t.Errorf("expected state %s after ejection, got %s", WorkerHealthy, state)
+	}
+}
+
+func TestWorkerHealth_Banned(t *testing.T) {
+	h := newWorkerHealth()
+	h.record(time.Millisecond, tgerr.New(401, "AUTH_KEY_UNREGISTERED"))
+	if state := h.state(time.Now()); state != WorkerBanned {
+		t.Errorf("expected state %s, got %s", WorkerBanned, state)
+	}
+}
+
+func TestWorkerHealth_ReconnectBackoff(t *testing.T) {
+	h := newWorkerHealth()
+	pingErr := errors.New("ping timeout")
+	for i := 0; i < healthMaxPingFailures-1; i++ {
+		if h.checked(0, pingErr) {
+			t.Fatal("should not reconnect before reaching the failure threshold")
+		}
+	}
+	if !h.checked(0, pingErr) {
+		t.Fatal("expected a reconnect attempt")
+	}
+	if h.checked(0, pingErr) {
+		t.Fatal("should not start a second reconnect while one is running")
+	}
+	h.reconnected(pingErr)
+	if h.checked(0, pingErr) {
+		t.Fatal("should wait for the backoff before reconnecting again")
+	}
+	h.checked(0, nil)
+	if state := h.state(time.Now()); state != WorkerHealthy {
+		t.Errorf("expected state %s after a successful check, got %s", WorkerHealthy, state)
+	}
+}
+
+// Errors caused by the request must not eject a healthy worker.
+func TestWorkerHealth_RequestErrors(t *testing.T) {
+	h := newWorkerHealth()
+	for i := 0; i < healthWindowSize; i++ {
+		h.record(time.Millisecond, tgerr.New(400, "FILE_REFERENCE_EXPIRED"))
+		h.record(time.Millisecond, tgerr.New(400, "OFFSET_INVALID"))
+		h.record(time.Millisecond, tgerr.New(400, "MESSAGE_ID_INVALID"))
+	}
+	if state := h.state(time.Now()); state != WorkerHealthy {
+		t.Fatalf("expected state %s, got %s", WorkerHealthy, state)
+	}
+	if len(h.outcomes) != 0 || h.lastError != "" {
+		t.Errorf("request errors were recorded: %d outcomes, last error %q", len(h.outcomes), h.lastError)
+	}
+}
+
+func TestWorkerFailure(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("connection reset"), true},
+		{tgerr.New(500, "INTERNAL"), true},
+		{tgerr.New(503, "Timeout"), true},
+		{tgerr.New(401, "AUTH_KEY_UNREGISTERED"), true},
+		{tgerr.New(400, "ACCESS_TOKEN_INVALID"), true},
+		{tgerr.New(400, "LIMIT_INVALID"), false},
+		{tgerr.New(420, "FLOOD_WAIT_30"), false},
+	}
+	for _, tt := range tests {
+		if got := workerFailure(tt.err); got != tt.want {
+			t.Errorf("workerFailure(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+// newOfflineClient returns a client that is never connected, enough to call
+// API on.
+func newOfflineClient() *gotgproto.Client {
+	return &gotgproto.Client{Client: telegram.NewClient(1, "hash", telegram.Options{})}
+}
+
+// Reconnecting a worker while streams use its client is race free, and the
+// old client is only stopped once the streams that got it are done. Run
+// with -race.
+func TestReconnectWhileInUse(t *testing.T) {
+	oldInterval, oldStop := drainPollInterval, stopClient
+	drainPollInterval = 5 * time.Millisecond
+	var stopMut sync.Mutex
+	stopped := make(map[*gotgproto.Client]bool)
+	stopClient = func(client *gotgproto.Client) {
+		stopMut.Lock()
+		defer stopMut.Unlock()
+		stopped[client] = true
+	}
+	defer func() { drainPollInterval, stopClient = oldInterval, oldStop }()
+	isStopped := func(client *gotgproto.Client) bool {
+		stopMut.Lock()
+		defer stopMut.Unlock()
+		return stopped[client]
+	}
+
+	worker := newTestWorker(1)
+	worker.log = zap.NewNop()
+	worker.token = "1:token" // not the default worker, which would replace Bot
+	worker.client = newOfflineClient()
+	worker.newClient = func() (*gotgproto.Client, error) { return newOfflineClient(), nil }
+
+	// a long running stream holding the first client
+	worker.StreamStarted()
+	first := worker.Client()
+
+	var stop atomic.Bool
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !stop.Load() {
+				worker.StreamStarted()
+				client := worker.Client()
+				if client.API() == nil {
+					t.Error("got a client without API")
+				}
+				if isStopped(client) {
+					t.Error("got a stopped client")
+				}
+				worker.StreamFinished()
+			}
+		}()
+	}
+	for i := 0; i < 3; i++ {
+		worker.reconnect()
+	}
+	stop.Store(true)
+	wg.Wait()
+
+	if worker.Client() == first {
+		t.Fatal("reconnect didn't swap the client")
+	}
+	if isStopped(first) {
+		t.Fatal("the old client was stopped while a stream still used it")
+	}
+	first.API()
+	worker.StreamFinished()
+	// every client replaced by the 3 reconnects is stopped once the worker
+	// is idle
+	stoppedCount := func() int {
+		stopMut.Lock()
+		defer stopMut.Unlock()
+		return len(stopped)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for stoppedCount() < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d of the 3 old clients were stopped once the worker was idle", stoppedCount())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !isStopped(first) {
+		t.Error("the first client wasn't stopped")
+	}
+	if isStopped(worker.Client()) {
+		t.Error("the current client was stopped")
+	}
+}
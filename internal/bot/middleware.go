@@ -1,20 +1,93 @@
 package bot
 
 import (
+	"EverythingSuckz/fsb/internal/metrics"
+	"context"
+	"errors"
 	"time"
 
 	"github.com/gotd/contrib/middleware/floodwait"
 	"github.com/gotd/contrib/middleware/ratelimit"
+	"github.com/gotd/td/bin"
 	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
 	"go.uber.org/zap"
 	"golang.org/x/time/rate"
 )
 
-func GetFloodMiddleware(log *zap.Logger) []telegram.Middleware {
+func GetFloodMiddleware(log *zap.Logger, worker string) []telegram.Middleware {
 	waiter := floodwait.NewSimpleWaiter().WithMaxRetries(10)
 	ratelimiter := ratelimit.New(rate.Every(time.Millisecond*100), 5)
 	return []telegram.Middleware{
 		waiter,
+		floodWaitPassthrough(worker),
 		ratelimiter,
 	}
 }
+
+type noFloodWaitKey struct{}
+
+// WithoutFloodWait marks the calls made with ctx so a FLOOD_WAIT is returned
+// to the caller right away as a *FloodWaitError instead of being waited out.
+func WithoutFloodWait(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noFloodWaitKey{}, true)
+}
+
+// FloodWaitError is a FLOOD_WAIT returned to a WithoutFloodWait caller. It
+// doesn't wrap the RPC error so neither the flood waiter nor the uploader
+// sleep on it.
+type FloodWaitError struct {
+	Duration time.Duration
+	err      error
+}
+
+func (e *FloodWaitError) Error() string {
+	return e.err.Error()
+}
+
+func floodWaitPassthrough(worker string) telegram.Middleware {
+	return telegram.MiddlewareFunc(func(next tg.Invoker) telegram.InvokeFunc {
+		return func(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+			err := next.Invoke(ctx, input, output)
+			d, ok := tgerr.AsFloodWait(err)
+			if !ok {
+				return err
+			}
+			metrics.FloodWaits.WithLabelValues(worker).Inc()
+			metrics.FloodWaitSeconds.WithLabelValues(worker).Add(d.Seconds())
+			if ctx.Value(noFloodWaitKey{}) != nil {
+				return &FloodWaitError{Duration: d, err: err}
+			}
+			return err
+		}
+	})
+}
+
+// getFileMetrics records the latency and errors of the upload.getFile calls
+// made by a worker.
+func getFileMetrics(worker string) telegram.Middleware {
+	return telegram.MiddlewareFunc(func(next tg.Invoker) telegram.InvokeFunc {
+		return func(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+			if _, ok := input.(*tg.UploadGetFileRequest); !ok {
+				return next.Invoke(ctx, input, output)
+			}
+			start := time.Now()
+			err := next.Invoke(ctx, input, output)
+			metrics.GetFileDuration.WithLabelValues(worker).Observe(time.Since(start).Seconds())
+			if err != nil {
+				metrics.GetFileErrors.WithLabelValues(worker).Inc()
+			}
+			return err
+		}
+	})
+}
+
+// AsFloodWait returns the wait duration of a FLOOD_WAIT error.
+func AsFloodWait(err error) (time.Duration, bool) {
+	var floodErr *FloodWaitError
+	if errors.As(err, &floodErr) {
+		return floodErr.Duration, true
+	}
+	return tgerr.AsFloodWait(err)
+}
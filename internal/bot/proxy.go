@@ -0,0 +1,74 @@
+package bot
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/utils"
+
+	"github.com/gotd/td/telegram/dcs"
+	"go.uber.org/zap"
+)
+
+// newResolver returns a resolver dialing Telegram through the given proxies,
+// failing over from one to the next, or the default resolver if there are
+// none. An MTProxy can't fail over: if the first proxy is an MTProxy link it
+// is used alone, otherwise the MTProxy links of the list are skipped.
+func newResolver(log *zap.Logger, proxies []string) (dcs.Resolver, error) {
+	if len(proxies) == 0 {
+		return dcs.DefaultResolver(), nil
+	}
+	redacted := make([]string, 0, len(proxies))
+	for _, proxyURL := range proxies {
+		if err := utils.ValidateProxyURL(proxyURL); err != nil {
+			return nil, err
+		}
+		redacted = append(redacted, utils.RedactProxyURL(proxyURL))
+	}
+	if utils.IsMTProxyURL(proxies[0]) {
+		log.Info("Using Telegram proxy", zap.String("proxy", redacted[0]))
+		mtproxy, err := utils.ParseMTProxyURL(proxies[0])
+		if err != nil {
+			return nil, err
+		}
+		return dcs.MTProxy(mtproxy.Addr, mtproxy.Secret, dcs.MTProxyOptions{})
+	}
+	var pool, used []string
+	for i, proxyURL := range proxies {
+		if utils.IsMTProxyURL(proxyURL) {
+			continue
+		}
+		pool = append(pool, proxyURL)
+		used = append(used, redacted[i])
+	}
+	log.Info("Using Telegram proxy", zap.Strings("proxies", used))
+	dialer, err := utils.NewProxyPool(pool)
+	if err != nil {
+		return nil, err
+	}
+	return dcs.Plain(dcs.PlainOptions{
+		Dial: dialer.DialContext,
+	}), nil
+}
+
+// telegramProxies returns the proxies of TELEGRAM_PROXY.
+func telegramProxies() []string {
+	return utils.SplitProxyList(config.ValueOf.TelegramProxy)
+}
+
+// workerProxies returns the proxies of the worker with the given index.
+// Workers are spread over WORKER_PROXIES in turn and fail over to the other
+// proxies of the list. Without WORKER_PROXIES, TELEGRAM_PROXY is used.
+func workerProxies(index int) []string {
+	proxies := config.ValueOf.WorkerProxies
+	if len(proxies) == 0 {
+		return telegramProxies()
+	}
+	start := (index - 1) % len(proxies)
+	return append(append([]string(nil), proxies[start:]...), proxies[:start]...)
+}
+
+func firstProxy(proxies []string) string {
+	if len(proxies) == 0 {
+		return ""
+	}
+	return proxies[0]
+}
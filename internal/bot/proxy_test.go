@@ -0,0 +1,196 @@
+package bot
+
+import (
+	"EverythingSuckz/fsb/config"
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gotd/td/telegram/dcs"
+	"github.com/gotd/td/tg"
+	"go.uber.org/zap"
+)
+
+func useProxyConfig(t *testing.T, telegramProxy string, workerProxies []string) {
+	oldTelegram, oldWorkers := config.ValueOf.TelegramProxy, config.ValueOf.WorkerProxies
+	config.ValueOf.TelegramProxy, config.ValueOf.WorkerProxies = telegramProxy, workerProxies
+	t.Cleanup(func() { config.ValueOf.TelegramProxy, config.ValueOf.WorkerProxies = oldTelegram, oldWorkers })
+}
+
+func TestWorkerProxies(t *testing.T) {
+	proxies := []string{"socks5://a:1080", "socks5://b:1080", "http://c:3128"}
+	useProxyConfig(t, "socks5://main:1080", proxies)
+	tests := []struct {
+		index int
+		want  []string
+	}{
+		{1, []string{"socks5://a:1080", "socks5://b:1080", "http://c:3128"}},
+		{2, []string{"socks5://b:1080", "http://c:3128", "socks5://a:1080"}},
+		{3, []string{"http://c:3128", "socks5://a:1080", "socks5://b:1080"}},
+		{4, []string{"socks5://a:1080", "socks5://b:1080", "http://c:3128"}},
+		{5, []string{"socks5://b:1080", "http://c:3128", "socks5://a:1080"}},
+	}
+	for _, test := range tests {
+		if got := workerProxies(test.index); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("worker %d: got %v, want %v", test.index, got, test.want)
+		}
+	}
+	// the returned lists are copies
+	workerProxies(2)[0] = "changed"
+	if proxies[1] != "socks5://b:1080" {
+		t.Error("workerProxies modified WORKER_PROXIES")
+	}
+}
+
+func TestWorkerProxiesFallback(t *testing.T) {
+	useProxyConfig(t, "socks5://main:1080, http://backup:3128", nil)
+	want := []string{"socks5://main:1080", "http://backup:3128"}
+	for index := 1; index <= 3; index++ {
+		if got := workerProxies(index); !reflect.DeepEqual(got, want) {
+			t.Errorf("worker %d: got %v, want TELEGRAM_PROXY %v", index, got, want)
+		}
+	}
+	useProxyConfig(t, "", nil)
+	if got := workerProxies(1); len(got) != 0 {
+		t.Errorf("expected no proxy, got %v", got)
+	}
+	if got := firstProxy(workerProxies(1)); got != "" {
+		t.Errorf("expected no proxy, got %q", got)
+	}
+}
+
+func TestNewResolver(t *testing.T) {
+	const mtproxy = "tg://proxy?server=1.2.3.4&port=443&secret=dd00112233445566778899aabbccddeeff"
+	tests := []struct {
+		name    string
+		proxies []string
+		mtproxy bool
+		wantErr bool
+	}{
+		{name: "no proxy"},
+		{name: "socks5", proxies: []string{"socks5://127.0.0.1:1080"}},
+		{name: "failover list", proxies: []string{"socks5://127.0.0.1:1080", "http://127.0.0.1:3128"}},
+		{name: "mtproxy", proxies: []string{mtproxy}, mtproxy: true},
+		{name: "mtproxy in a list", proxies: []string{"socks5://127.0.0.1:1080", mtproxy}},
+		{name: "mtproxy first in a list", proxies: []string{mtproxy, "socks5://127.0.0.1:1080"}, mtproxy: true},
+		{name: "unsupported scheme", proxies: []string{"ftp://127.0.0.1:21"}, wantErr: true},
+		{name: "invalid mtproxy", proxies: []string{"tg://proxy?server=1.2.3.4&port=0&secret=dd00"}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resolver, err := newResolver(zap.NewNop(), test.proxies)
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resolver == nil {
+				t.Fatal("got a nil resolver")
+			}
+			if isMTProxy := strings.Contains(strings.ToLower(fmt.Sprintf("%T", resolver)), "mtproxy"); isMTProxy != test.mtproxy {
+				t.Errorf("got a %T resolver, want MTProxy %v", resolver, test.mtproxy)
+			}
+		})
+	}
+}
+
+// Every worker starts when WORKER_PROXIES mixes MTProxy links with other
+// proxies.
+func TestNewResolverMixedWorkerProxies(t *testing.T) {
+	useProxyConfig(t, "", []string{
+		"tg://proxy?server=1.2.3.4&port=443&secret=dd00112233445566778899aabbccddeeff",
+		"socks5://127.0.0.1:1080",
+		"tg://proxy?server=5.6.7.8&port=443&secret=00112233445566778899aabbccddeeff",
+	})
+	for index := 1; index <= 3; index++ {
+		if _, err := newResolver(zap.NewNop(), workerProxies(index)); err != nil {
+			t.Errorf("worker %d: %v", index, err)
+		}
+	}
+}
+
+// connectProxy is an HTTP CONNECT proxy recording the requested addresses.
+// It accepts every tunnel without connecting anywhere.
+type connectProxy struct {
+	addr string
+	mut  sync.Mutex
+	reqs []string
+}
+
+func startConnectProxy(t *testing.T) *connectProxy {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	p := &connectProxy{addr: ln.Addr().String()}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil {
+					return
+				}
+				p.mut.Lock()
+				p.reqs = append(p.reqs, req.Host)
+				p.mut.Unlock()
+				conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+				conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+				conn.Read(make([]byte, 1024))
+			}()
+		}
+	}()
+	return p
+}
+
+func (p *connectProxy) requested() []string {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	return append([]string(nil), p.reqs...)
+}
+
+// A worker connects to Telegram through the next proxy of its list when its
+// own proxy is down.
+func TestNewResolverFailover(t *testing.T) {
+	down, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	downAddr := down.Addr().String()
+	down.Close()
+	up := startConnectProxy(t)
+	useProxyConfig(t, "", []string{"http://" + up.addr, "http://" + downAddr})
+
+	resolver, err := newResolver(zap.NewNop(), workerProxies(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := resolver.Primary(ctx, 2, dcs.List{Options: []tg.DCOption{
+		{ID: 2, IPAddress: "149.154.167.50", Port: 443},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if got := up.requested(); !reflect.DeepEqual(got, []string{"149.154.167.50:443"}) {
+		t.Errorf("expected a tunnel to DC 2 through the second proxy, got %v", got)
+	}
+}
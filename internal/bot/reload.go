@@ -0,0 +1,137 @@
+package bot
+
+import (
+	"EverythingSuckz/fsb/config"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// a removed worker is stopped after drainTimeout even if still busy
+const drainTimeout = 30 * time.Minute
+
+// drainPollInterval is how often a removed worker is checked for remaining
+// streams and uploads, tests shorten it.
+var drainPollInterval = time.Second
+
+// selectionMut is held for reading while a worker is picked and its stream
+// or upload registered, and for writing while workers are taken out of
+// rotation. A removed worker gets no new request once removeWorkers returns.
+var selectionMut sync.RWMutex
+
+// ReloadResult summarizes a worker reload.
+type ReloadResult struct {
+	Added   int `json:"added"`
+	Failed  int `json:"failed"`
+	Removed int `json:"removed"`
+	Total   int `json:"total"`
+}
+
+var reloadMut sync.Mutex
+
+// ReloadWorkers re-reads the worker tokens, starts workers for new tokens and
+// drains the workers whose token was removed. The default bot is never removed.
+func ReloadWorkers() (*ReloadResult, error) {
+	if Workers.log == nil {
+		return nil, errors.New("workers are not running")
+	}
+	reloadMut.Lock()
+	defer reloadMut.Unlock()
+	log := Workers.log.Named("Reload")
+
+	tokens, err := config.ReloadTokens(log)
+	if err != nil {
+		return nil, err
+	}
+	wanted := make(map[string]bool, len(tokens))
+	for _, token := range tokens {
+		wanted[token.Token] = true
+	}
+	running := make(map[string]bool)
+	for _, worker := range Workers.snapshot() {
+		if worker.token != "" {
+			running[worker.token] = true
+		}
+	}
+	var added []config.WorkerToken
+	for _, token := range tokens {
+		if !running[token.Token] {
+			added = append(added, token)
+		}
+	}
+
+	result := &ReloadResult{}
+	if len(added) > 0 {
+		if config.ValueOf.UseSessionFile {
+			if err := os.MkdirAll(filepath.Join(".", "sessions"), os.ModePerm); err != nil {
+				return nil, err
+			}
+		}
+		result.Added = startTokens(added)
+		result.Failed = len(added) - result.Added
+	}
+
+	// new workers are in rotation before the old ones leave it
+	removed := removeWorkers(wanted)
+	result.Total = len(Workers.snapshot())
+	result.Removed = len(removed)
+	for _, worker := range removed {
+		go worker.drain()
+	}
+	log.Info("Workers reloaded",
+		zap.Int("added", result.Added),
+		zap.Int("failed", result.Failed),
+		zap.Int("removed", result.Removed),
+		zap.Int("total", result.Total))
+	return result, nil
+}
+
+// removeWorkers takes the workers whose token isn't wanted out of rotation
+// and returns them. The default bot is kept.
+func removeWorkers(wanted map[string]bool) []*Worker {
+	selectionMut.Lock()
+	defer selectionMut.Unlock()
+	var removed []*Worker
+	Workers.mut.Lock()
+	kept := make([]*Worker, 0, len(Workers.Bots))
+	for _, worker := range Workers.Bots {
+		if worker.token == "" || wanted[worker.token] {
+			kept = append(kept, worker)
+		} else {
+			removed = append(removed, worker)
+		}
+	}
+	Workers.Bots = kept
+	Workers.mut.Unlock()
+	syncUploadWorkers()
+	return removed
+}
+
+// drain waits for the streams and uploads of a worker taken out of rotation
+// to finish and stops its client.
+func (w *Worker) drain() {
+	log := w.log.With(zap.String("worker", w.Label))
+	log.Info("Draining worker")
+	if !w.waitIdle(drainTimeout) {
+		log.Warn("Worker still busy after drain timeout, stopping anyway", zap.Duration("timeout", drainTimeout))
+	}
+	w.Client().Stop()
+	log.Info("Worker stopped")
+}
+
+// waitIdle waits up to timeout for the streams and uploads of the worker to
+// finish and reports whether they did.
+func (w *Worker) waitIdle(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for w.busy() {
+		if !time.Now().Before(deadline) {
+			return false
+		}
+		time.Sleep(drainPollInterval)
+	}
+	return true
+}
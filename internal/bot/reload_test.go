@@ -0,0 +1,113 @@
+package bot
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Streams and uploads keep being served while a worker is removed: once it
+// is idle it must never be picked again.
+func TestReloadWhileStreaming(t *testing.T) {
+	bots := useTestWorkers(t, StrategyRoundRobin, 3)
+	bots[0].token, bots[1].token = "", "kept"
+	removedWorker := bots[2]
+	removedWorker.token = "removed"
+	newTestUploadManager(bots...)
+	defer func() { uploadManager = nil }()
+	oldInterval := drainPollInterval
+	drainPollInterval = 5 * time.Millisecond
+	defer func() { drainPollInterval = oldInterval }()
+
+	var (
+		stop       atomic.Bool
+		idle       atomic.Bool
+		pickedIdle atomic.Int32
+		requests   atomic.Int32
+		wg         sync.WaitGroup
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for messageID := i; !stop.Load(); messageID += 8 {
+				wasIdle := idle.Load()
+				var worker *Worker
+				if i%2 == 0 {
+					worker = StartStream(messageID)
+				} else {
+					var err error
+					worker, err = AcquireUploadWorker(context.Background(), nil)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+				}
+				requests.Add(1)
+				if wasIdle && worker == removedWorker {
+					pickedIdle.Add(1)
+				}
+				time.Sleep(time.Millisecond)
+				if i%2 == 0 {
+					worker.StreamFinished()
+				} else {
+					worker.UploadFinished()
+				}
+			}
+		}(i)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	removed := removeWorkers(map[string]bool{"kept": true})
+	if len(removed) != 1 || removed[0] != removedWorker {
+		t.Fatalf("removed %v, want worker %d", removed, removedWorker.ID)
+	}
+	for _, worker := range Workers.snapshot() {
+		if worker == removedWorker {
+			t.Fatal("removed worker is still in rotation")
+		}
+	}
+	for _, worker := range uploadManager.workers {
+		if worker == removedWorker {
+			t.Fatal("removed worker is still used for uploads")
+		}
+	}
+	if !removedWorker.waitIdle(time.Second) {
+		t.Fatal("removed worker never became idle")
+	}
+	idle.Store(true)
+	before := requests.Load()
+	time.Sleep(30 * time.Millisecond)
+	stop.Store(true)
+	wg.Wait()
+
+	if requests.Load() == before {
+		t.Fatal("no request was served after the reload")
+	}
+	if n := pickedIdle.Load(); n != 0 {
+		t.Errorf("removed worker was picked %d times after it was drained", n)
+	}
+	if removedWorker.busy() {
+		t.Error("removed worker got new requests after it was drained")
+	}
+}
+
+func TestWaitIdleTimeout(t *testing.T) {
+	oldInterval := drainPollInterval
+	drainPollInterval = 5 * time.Millisecond
+	defer func() { drainPollInterval = oldInterval }()
+	worker := newTestWorker(1)
+	worker.StreamStarted()
+	if worker.waitIdle(20 * time.Millisecond) {
+		t.Fatal("busy worker reported idle")
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		worker.StreamFinished()
+	}()
+	if !worker.waitIdle(time.Second) {
+		t.Fatal("worker didn't become idle after its stream finished")
+	}
+}
@@ -0,0 +1,214 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/celestix/gotgproto"
+	"github.com/celestix/gotgproto/storage"
+	"github.com/glebarez/sqlite"
+	"github.com/gotd/td/session"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// SessionDir holds the session files of the worker bots.
+const SessionDir = "sessions"
+
+var (
+	sessionFileRegex = regexp.MustCompile(`^bot-(\d+)\.session$`)
+	// sessions of older versions, keyed by the start order of the workers
+	legacySessionRegex = regexp.MustCompile(`^worker-\d+\.session$`)
+)
+
+var (
+	// ErrSessionNotLoggedIn is returned for session files without an auth key.
+	ErrSessionNotLoggedIn = errors.New("session is not logged in")
+	// ErrSessionMismatch is returned for session files of another bot.
+	ErrSessionMismatch = errors.New("session belongs to another bot")
+)
+
+// SessionPath returns the session file of the worker bot with the given ID.
+// Sessions are keyed by bot ID so they stay with their token whatever order
+// the tokens are loaded in.
+func SessionPath(botID int64) string {
+	return filepath.Join(SessionDir, fmt.Sprintf("bot-%d.session", botID))
+}
+
+// SessionFile is a file of the sessions directory.
+type SessionFile struct {
+	Path string
+	// BotID is the bot the file is named after, 0 if unknown.
+	BotID int64
+	// Legacy is set for sessions/worker-<index>.session files of older
+	// versions, named after the start order of the workers rather than their
+	// token, see MigrateLegacySessions.
+	Legacy  bool
+	Size    int64
+	ModTime time.Time
+}
+
+// ListSessions returns the session files of the sessions directory.
+func ListSessions() ([]SessionFile, error) {
+	entries, err := os.ReadDir(SessionDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var files []SessionFile
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".session" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		file := SessionFile{
+			Path:    filepath.Join(SessionDir, entry.Name()),
+			Legacy:  legacySessionRegex.MatchString(entry.Name()),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		}
+		if match := sessionFileRegex.FindStringSubmatch(entry.Name()); match != nil {
+			file.BotID, _ = strconv.ParseInt(match[1], 10, 64)
+		}
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
+// VerifySession checks that a session file can be read, is logged in and
+// belongs to the bot with the given ID.
+func VerifySession(path string, botID int64) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		return err
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		defer sqlDB.Close()
+	}
+	if !db.Migrator().HasTable(&storage.Session{}) {
+		return ErrSessionNotLoggedIn
+	}
+	var stored storage.Session
+	if err := db.Limit(1).Find(&stored).Error; err != nil {
+		return err
+	}
+	if len(stored.Data) == 0 {
+		return ErrSessionNotLoggedIn
+	}
+	var data struct {
+		Data session.Data
+	}
+	if err := json.Unmarshal(stored.Data, &data); err != nil {
+		return fmt.Errorf("invalid session data: %w", err)
+	}
+	if len(data.Data.AuthKey) == 0 {
+		return ErrSessionNotLoggedIn
+	}
+	// the client stores the bot itself as a user peer once logged in
+	var self int64
+	if db.Migrator().HasTable(&storage.Peer{}) {
+		err := db.Model(&storage.Peer{}).
+			Where("id = ? AND type = ?", botID, storage.TypeUser.GetInt()).
+			Count(&self).Error
+		if err != nil {
+			return err
+		}
+	}
+	if self == 0 {
+		return fmt.Errorf("%w: no record of bot %d", ErrSessionMismatch, botID)
+	}
+	return nil
+}
+
+// SessionMigration is a session file of an older version renamed after the
+// bot it is logged in as.
+type SessionMigration struct {
+	From  string
+	To    string
+	BotID int64
+}
+
+// MigrateLegacySessions renames the worker-<index>.session files of older
+// versions to bot-<id>.session when they are logged in as exactly one of
+// botIDs and that bot has no session file yet. Other legacy files are left
+// alone. With dryRun the files are only checked.
+func MigrateLegacySessions(botIDs []int64, dryRun bool) ([]SessionMigration, error) {
+	files, err := ListSessions()
+	if err != nil {
+		return nil, err
+	}
+	var migrations []SessionMigration
+	for _, file := range files {
+		if !file.Legacy {
+			continue
+		}
+		botID := legacySessionBot(file.Path, botIDs)
+		if botID == 0 {
+			continue
+		}
+		to := SessionPath(botID)
+		if _, err := os.Stat(to); !os.IsNotExist(err) {
+			continue
+		}
+		if !dryRun {
+			if err := os.Rename(file.Path, to); err != nil {
+				return migrations, err
+			}
+		}
+		migrations = append(migrations, SessionMigration{From: file.Path, To: to, BotID: botID})
+	}
+	return migrations, nil
+}
+
+// legacySessionBot returns the bot of botIDs the session file is logged in
+// as, or 0 if it matches none or more than one of them.
+func legacySessionBot(path string, botIDs []int64) int64 {
+	var found int64
+	for _, botID := range botIDs {
+		if VerifySession(path, botID) != nil {
+			continue
+		}
+		if found != 0 && found != botID {
+			return 0
+		}
+		found = botID
+	}
+	return found
+}
+
+// closeSession closes the session database of a stopped client.
+func closeSession(client *gotgproto.Client) {
+	if client.PeerStorage != nil && client.PeerStorage.SqlSession != nil {
+		if sqlDB, err := client.PeerStorage.SqlSession.DB(); err == nil {
+			sqlDB.Close()
+		}
+	}
+}
+
+// removeSession closes the session database of a stopped client and
+// deletes its file.
+func removeSession(client *gotgproto.Client, path string) error {
+	closeSession(client)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
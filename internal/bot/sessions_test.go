@@ -0,0 +1,158 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/celestix/gotgproto/storage"
+	"github.com/glebarez/sqlite"
+	"github.com/gotd/td/session"
+)
+
+// writeTestSession creates a session file logged in as the bot with the given ID.
+func writeTestSession(t *testing.T, path string, botID int64, authKey []byte) {
+	t.Helper()
+	peers := storage.NewPeerStorage(sqlite.Open(path), false)
+	data, err := json.Marshal(struct {
+		Version int
+		Data    session.Data
+	}{Version: 1, Data: session.Data{DC: 2, AuthKey: authKey}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	peers.UpdateSession(&storage.Session{Version: storage.LatestVersion, Data: data})
+	peers.SqlSession.Save(&storage.Peer{ID: botID, Type: storage.TypeUser.GetInt(), Username: "test_bot"})
+	sqlDB, _ := peers.SqlSession.DB()
+	sqlDB.Close()
+}
+
+func TestVerifySession(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "bot-123.session")
+	writeTestSession(t, path, 123, []byte{1, 2, 3})
+
+	if err := VerifySession(path, 123); err != nil {
+		t.Fatalf("VerifySession() = %v, want nil", err)
+	}
+	if err := VerifySession(path, 456); !errors.Is(err, ErrSessionMismatch) {
+		t.Fatalf("VerifySession() = %v, want ErrSessionMismatch", err)
+	}
+
+	empty := filepath.Join(dir, "bot-789.session")
+	writeTestSession(t, empty, 789, nil)
+	if err := VerifySession(empty, 789); !errors.Is(err, ErrSessionNotLoggedIn) {
+		t.Fatalf("VerifySession() = %v, want ErrSessionNotLoggedIn", err)
+	}
+
+	if err := VerifySession(filepath.Join(dir, "missing.session"), 1); !os.IsNotExist(err) {
+		t.Fatalf("VerifySession() = %v, want a not exist error", err)
+	}
+}
+
+func TestListSessions(t *testing.T) {
+	t.Chdir(t.TempDir())
+	files, err := ListSessions()
+	if err != nil || len(files) != 0 {
+		t.Fatalf("ListSessions() = %v, %v, want no files", files, err)
+	}
+
+	if err := os.Mkdir(SessionDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"bot-42.session", "worker-1.session", "other.session", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(SessionDir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err = ListSessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("ListSessions() returned %d files, want 3", len(files))
+	}
+	want := map[string]SessionFile{
+		SessionPath(42): {BotID: 42},
+		filepath.Join(SessionDir, "worker-1.session"): {Legacy: true},
+		filepath.Join(SessionDir, "other.session"):    {},
+	}
+	for _, file := range files {
+		w, ok := want[file.Path]
+		if !ok {
+			t.Fatalf("unexpected file %s", file.Path)
+		}
+		if file.BotID != w.BotID || file.Legacy != w.Legacy {
+			t.Errorf("%s: got bot ID %d legacy %v, want %d %v", file.Path, file.BotID, file.Legacy, w.BotID, w.Legacy)
+		}
+	}
+}
+
+func TestMigrateLegacySessions(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.Mkdir(SessionDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	legacy := func(index int) string {
+		return filepath.Join(SessionDir, "worker-"+strconv.Itoa(index)+".session")
+	}
+	writeTestSession(t, legacy(1), 100, []byte{1})
+	writeTestSession(t, legacy(2), 200, []byte{2})
+	// bot 300 already has a session of its own
+	writeTestSession(t, legacy(3), 300, []byte{3})
+	writeTestSession(t, SessionPath(300), 300, []byte{4})
+	// not a configured bot
+	writeTestSession(t, legacy(4), 400, []byte{5})
+	// never logged in
+	writeTestSession(t, legacy(5), 500, nil)
+	botIDs := []int64{100, 200, 300, 500}
+
+	migrations, err := MigrateLegacySessions(botIDs, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 2 {
+		t.Fatalf("dry run found %v, want 2 migrations", migrations)
+	}
+	if _, err := os.Stat(legacy(1)); err != nil {
+		t.Fatalf("dry run moved a file: %v", err)
+	}
+
+	migrations, err = MigrateLegacySessions(botIDs, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []SessionMigration{
+		{From: legacy(1), To: SessionPath(100), BotID: 100},
+		{From: legacy(2), To: SessionPath(200), BotID: 200},
+	}
+	if !reflect.DeepEqual(migrations, want) {
+		t.Fatalf("got migrations %v, want %v", migrations, want)
+	}
+	for _, botID := range []int64{100, 200} {
+		if err := VerifySession(SessionPath(botID), botID); err != nil {
+			t.Errorf("migrated session of bot %d: %v", botID, err)
+		}
+	}
+	for _, index := range []int{3, 4, 5} {
+		if _, err := os.Stat(legacy(index)); err != nil {
+			t.Errorf("%s should be left alone: %v", legacy(index), err)
+		}
+	}
+	if _, err := os.Stat(legacy(1)); !os.IsNotExist(err) {
+		t.Errorf("%s should be gone, got %v", legacy(1), err)
+	}
+
+	// a session logged in as several configured bots can't be told apart
+	shared := legacy(6)
+	writeTestSession(t, shared, 600, []byte{6})
+	writeTestSession(t, shared, 700, []byte{6})
+	migrations, err = MigrateLegacySessions([]int64{600, 700}, false)
+	if err != nil || len(migrations) != 0 {
+		t.Fatalf("got %v, %v, want no migration for an ambiguous session", migrations, err)
+	}
+}
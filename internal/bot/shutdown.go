@@ -0,0 +1,36 @@
+package bot
+
+import (
+	"sync/atomic"
+
+	"github.com/celestix/gotgproto"
+	"go.uber.org/zap"
+)
+
+// shuttingDown stops the health monitor from reconnecting the clients
+// stopped by Shutdown.
+var shuttingDown atomic.Bool
+
+// Shutdown stops the main bot, the workers and the userbot and closes their
+// session databases. Streams and uploads should be finished or cancelled
+// before.
+func Shutdown(log *zap.Logger) {
+	log = log.Named("Shutdown")
+	shuttingDown.Store(true)
+	stopped := make(map[*gotgproto.Client]bool)
+	stop := func(name string, client *gotgproto.Client) {
+		if client == nil || stopped[client] {
+			return
+		}
+		stopped[client] = true
+		client.Stop()
+		closeSession(client)
+		log.Debug("Stopped client", zap.String("client", name))
+	}
+	for _, worker := range Workers.snapshot() {
+		stop(worker.Label, worker.Client())
+	}
+	stop("default", Bot())
+	stop("userbot", UserBot.client)
+	log.Info("Stopped all Telegram clients", zap.Int("clients", len(stopped)))
+}
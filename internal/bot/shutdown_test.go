@@ -0,0 +1,19 @@
+package bot
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestShutdown_StopsReconnects(t *testing.T) {
+	defer shuttingDown.Store(false)
+	Shutdown(zap.NewNop())
+	if !shuttingDown.Load() {
+		t.Fatal("expected Shutdown to mark the bot as shutting down")
+	}
+	// the worker has no client: reconnecting it would panic
+	worker := newTestWorker(1)
+	worker.log = zap.NewNop()
+	worker.reconnect()
+}
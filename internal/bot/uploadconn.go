@@ -0,0 +1,34 @@
+package bot
+
+import (
+	"fmt"
+
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+)
+
+// UploadConnections opens up to n extra MTProto connections to the worker's
+// DC on its bot session, so the parts of one big file can be uploaded over
+// several connections at once. Requests go through the worker's middlewares,
+// FLOOD_WAIT and health are tracked as on its main connection.
+// The returned close function must be called once the upload is done.
+func (w *Worker) UploadConnections(n int) (*tg.Client, func() error, error) {
+	pool, err := w.Client().Pool(int64(n))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open upload connections: %w", err)
+	}
+	var invoker tg.Invoker = pool
+	if w.opts != nil {
+		invoker = chainMiddlewares(invoker, w.opts.Middlewares...)
+	}
+	return tg.NewClient(invoker), pool.Close, nil
+}
+
+// chainMiddlewares wraps invoker like telegram.Client does, the first
+// middleware being the outermost.
+func chainMiddlewares(invoker tg.Invoker, middlewares ...telegram.Middleware) tg.Invoker {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		invoker = middlewares[i].Handle(invoker)
+	}
+	return invoker
+}
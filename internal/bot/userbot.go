@@ -2,6 +2,7 @@ package bot
 
 import (
 	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/pkg/sessionstring"
 	"errors"
 
 	"github.com/celestix/gotgproto"
@@ -24,13 +25,31 @@ func StartUserBot(l *zap.Logger) {
 		return
 	}
 	log.Sugar().Infoln("Starting userbot")
+	// USER_SESSION can be in any supported format, gotgproto loads it as its own
+	userSession, err := sessionstring.Decode(config.ValueOf.UserSession)
+	if err != nil {
+		log.Error("Invalid USER_SESSION", zap.Error(err))
+		return
+	}
+	log.Debug("Decoded user session", zap.String("format", string(userSession.Format)), zap.Int("dc", userSession.Data.DC))
+	stringSession, err := sessionstring.Encode(sessionstring.Gotgproto, userSession.Data, userSession.Account)
+	if err != nil {
+		log.Error("Failed to convert USER_SESSION", zap.Error(err))
+		return
+	}
+	resolver, err := newResolver(log, telegramProxies())
+	if err != nil {
+		log.Error("Invalid Telegram proxy configuration", zap.Error(err))
+		return
+	}
 	client, err := gotgproto.NewClient(
 		int(config.ValueOf.ApiID),
 		config.ValueOf.ApiHash,
 		gotgproto.ClientTypePhone(""),
 		&gotgproto.ClientOpts{
-			Session:          sessionMaker.PyrogramSession(config.ValueOf.UserSession),
+			Session:          sessionMaker.StringSession(stringSession),
 			DisableCopyright: true,
+			Resolver:         resolver,
 		},
 	)
 	if err != nil {
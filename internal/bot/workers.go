@@ -2,7 +2,9 @@ package bot
 
 import (
 	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/tracing"
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -18,14 +20,33 @@ import (
 )
 
 type Worker struct {
-	ID     int
-	Client *gotgproto.Client
-	Self   *tg.User
-	log    *zap.Logger
+	ID int
+	// Label names the worker in logs and stats, see config.WorkerToken.
+	Label     string
+	Self      *tg.User
+	log       *zap.Logger
+	token     string // empty for the default client
+	proxy     string
+	health    *workerHealth
+	load      *workerLoad
+	opts      *gotgproto.ClientOpts
+	clientMut sync.RWMutex
+	client    *gotgproto.Client
+	// newClient starts a new client for the worker on reconnect, nil if it
+	// can't be reconnected.
+	newClient func() (*gotgproto.Client, error)
+}
+
+// Client returns the worker's current client. A reconnect swaps in a new
+// client, the old one keeps working for the requests that already got it.
+func (w *Worker) Client() *gotgproto.Client {
+	w.clientMut.RLock()
+	defer w.clientMut.RUnlock()
+	return w.client
 }
 
 func (w *Worker) String() string {
-	return fmt.Sprintf("{Worker (%d|@%s)}", w.ID, w.Self.Username)
+	return fmt.Sprintf("{Worker (%s|@%s)}", w.Label, w.Self.Username)
 }
 
 type BotWorkers struct {
@@ -43,101 +64,168 @@ var Workers *BotWorkers = &BotWorkers{
 
 // 上传专用worker管理器
 type UploadWorkerManager struct {
-	workers       []*Worker
-	lastUse       map[int]time.Time // workerID -> 最后使用时间
-	mutex         sync.Mutex
-	cooldown      time.Duration       // API调用冷却时间
-	logger        *zap.Logger
-	currentIndex  int
+	workers      []*Worker
+	lastUse      map[int]time.Time // workerID -> 最后使用时间
+	mutex        sync.Mutex
+	cooldown     time.Duration // API调用冷却时间
+	queueTimeout time.Duration // 所有worker都不可用时最长排队时间
+	turn         chan struct{} // 排队顺序，同一时间只有队首请求选择worker
+	queued       atomic.Int32
+	logger       *zap.Logger
+	currentIndex int
 }
 
+// 上传排队时检查worker是否可用的最长间隔
+const uploadQueuePollInterval = time.Second
+
+// ErrNoUploadWorker 排队超时仍没有可用的上传worker
+var ErrNoUploadWorker = errors.New("no upload worker available")
+
 var uploadManager *UploadWorkerManager
 
 // 初始化上传worker管理器
 func InitUploadWorkerManager(log *zap.Logger, cooldownSeconds int) {
 	uploadManager = &UploadWorkerManager{
-		workers:   Workers.Bots,
-		lastUse:   make(map[int]time.Time),
-		mutex:     sync.Mutex{},
-		cooldown:   time.Duration(cooldownSeconds) * time.Second,
-		logger:    log.Named("UploadWorkerManager"),
+		workers:      Workers.snapshot(),
+		lastUse:      make(map[int]time.Time),
+		cooldown:     time.Duration(cooldownSeconds) * time.Second,
+		queueTimeout: time.Duration(config.ValueOf.UploadQueueTimeout) * time.Second,
+		turn:         make(chan struct{}, 1),
+		logger:       log.Named("UploadWorkerManager"),
 	}
 }
 
-// 获取下一个可用的上传worker
-func GetNextUploadWorker() *Worker {
+// 在worker列表变化后同步上传worker管理器
+func syncUploadWorkers() {
 	if uploadManager == nil {
-		return GetNextWorker() // 回退到普通选择
+		return
 	}
-
+	workers := Workers.snapshot()
 	uploadManager.mutex.Lock()
 	defer uploadManager.mutex.Unlock()
+	uploadManager.workers = workers
+	if len(workers) > 0 {
+		uploadManager.currentIndex %= len(workers)
+	}
+}
 
-	now := time.Now()
-
-	// 查找不在冷却期的worker
-	for i := 0; i < len(uploadManager.workers); i++ {
-		workerIndex := (uploadManager.currentIndex + i) % len(uploadManager.workers)
-		worker := uploadManager.workers[workerIndex]
+// AcquireUploadWorker 获取一个可用的上传worker。
+// 处于FLOOD_WAIT、不健康或冷却中的worker会被跳过；所有worker都不可用时按顺序排队，
+// 直到有worker可用、ctx结束或超过UPLOAD_QUEUE_TIMEOUT。
+// tried中的worker（之前上传失败的）只有在没有其他worker时才会再次使用。
+// 返回的worker已经标记了上传，上传结束后必须调用UploadFinished。
+func AcquireUploadWorker(ctx context.Context, tried map[int]bool) (*Worker, error) {
+	if uploadManager == nil {
+		// 回退到普通选择
+		selectionMut.RLock()
+		defer selectionMut.RUnlock()
+		worker := GetNextWorker()
+		worker.UploadStarted()
+		return worker, nil
+	}
+	m := uploadManager
+
+	m.queued.Add(1)
+	defer m.queued.Add(-1)
+	if m.queueTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.queueTimeout)
+		defer cancel()
+	}
+	select {
+	case m.turn <- struct{}{}:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%w: %w", ErrNoUploadWorker, ctx.Err())
+	}
+	defer func() { <-m.turn }()
 
-		// 检查worker是否可用
-		if lastUse, exists := uploadManager.lastUse[worker.ID]; !exists ||
-			now.Sub(lastUse) > uploadManager.cooldown {
-			uploadManager.currentIndex = workerIndex
-			uploadManager.lastUse[worker.ID] = now
-			uploadManager.logger.Debug("选择上传worker",
-				zap.Int("workerID", worker.ID),
-				zap.String("username", worker.Self.Username),
-				zap.Duration("cooldownWait", now.Sub(lastUse)))
-			return worker
+	waiting := false
+	for {
+		worker, wait := m.pick(tried)
+		if worker != nil {
+			return worker, nil
+		}
+		if !waiting {
+			waiting = true
+			m.logger.Warn("所有上传worker都不可用，排队等待", zap.Duration("wait", wait))
+		}
+		if wait <= 0 || wait > uploadQueuePollInterval {
+			wait = uploadQueuePollInterval
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("%w: %w", ErrNoUploadWorker, ctx.Err())
 		}
 	}
+}
 
-	// 所有worker都在冷却，选择等待时间最短的
-	shortestWait := time.Hour
-	var selectedWorker *Worker
-
-	for _, worker := range uploadManager.workers {
-		if lastUse, exists := uploadManager.lastUse[worker.ID]; exists {
-			waitTime := uploadManager.cooldown - now.Sub(lastUse)
-			if waitTime < shortestWait {
-				shortestWait = waitTime
-				selectedWorker = worker
-			}
-		} else {
-			shortestWait = 0
-			selectedWorker = worker
-			break
+// pick 选择一个可用的worker并标记上传，没有时返回最快可用需要等待的时间
+func (m *UploadWorkerManager) pick(tried map[int]bool) (*Worker, time.Duration) {
+	// 重载时被移除的worker不会再被选中
+	selectionMut.RLock()
+	defer selectionMut.RUnlock()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	total := len(m.workers)
+	if total == 0 {
+		return nil, uploadQueuePollInterval
+	}
+	// 还有没试过的worker时，不再使用失败过的worker
+	untried := 0
+	for _, worker := range m.workers {
+		if !tried[worker.ID] {
+			untried++
 		}
 	}
 
-	if selectedWorker != nil {
-		uploadManager.currentIndex = (uploadManager.currentIndex + 1) % len(uploadManager.workers)
-		uploadManager.lastUse[selectedWorker.ID] = now
-
-		if shortestWait > 0 {
-			uploadManager.logger.Warn("所有worker都在冷却期，选择等待时间最短的",
-				zap.Int("workerID", selectedWorker.ID),
-				zap.String("username", selectedWorker.Self.Username),
-				zap.Duration("waitTime", shortestWait))
-		} else {
-			uploadManager.logger.Debug("选择可用上传worker",
-				zap.Int("workerID", selectedWorker.ID),
-				zap.String("username", selectedWorker.Self.Username))
+	now := time.Now()
+	shortestWait := time.Duration(-1)
+	for i := 0; i < total; i++ {
+		workerIndex := (m.currentIndex + i) % total
+		worker := m.workers[workerIndex]
+		if untried > 0 && tried[worker.ID] {
+			continue
 		}
 
-		return selectedWorker
+		// 检查worker是否可用
+		wait := time.Duration(0)
+		if until := worker.floodWaitUntil(); now.Before(until) {
+			wait = until.Sub(now)
+		} else if !worker.Healthy() {
+			wait = uploadQueuePollInterval
+		}
+		if lastUse, exists := m.lastUse[worker.ID]; exists {
+			if cooldown := m.cooldown - now.Sub(lastUse); cooldown > wait {
+				wait = cooldown
+			}
+		}
+		if wait <= 0 {
+			m.currentIndex = (workerIndex + 1) % total
+			m.lastUse[worker.ID] = now
+			m.logger.Debug("选择上传worker",
+				zap.Int("workerID", worker.ID),
+				zap.String("label", worker.Label))
+			worker.UploadStarted()
+			return worker, 0
+		}
+		if shortestWait < 0 || wait < shortestWait {
+			shortestWait = wait
+		}
 	}
-
-	return GetNextWorker() // 如果没有worker可用，回退到普通选择
+	return nil, shortestWait
 }
 
 // 获取worker统计信息
 func GetUploadWorkerStats() map[string]interface{} {
 	if uploadManager == nil {
+		total := len(Workers.snapshot())
 		return map[string]interface{}{
-			"totalWorkers": len(Workers.Bots),
-			"availableWorkers": len(Workers.Bots),
+			"totalWorkers":         total,
+			"availableWorkers":     total,
 			"uploadManagerEnabled": false,
 		}
 	}
@@ -148,24 +236,27 @@ func GetUploadWorkerStats() map[string]interface{} {
 	now := time.Now()
 	availableCount := 0
 	cooldownCount := 0
+	floodWaitCount := 0
 
 	for _, worker := range uploadManager.workers {
-		if lastUse, exists := uploadManager.lastUse[worker.ID]; exists {
-			if now.Sub(lastUse) > uploadManager.cooldown {
-				availableCount++
-			} else {
-				cooldownCount++
-			}
-		} else {
-			availableCount++
+		if now.Before(worker.floodWaitUntil()) {
+			floodWaitCount++
+			continue
+		}
+		if lastUse, exists := uploadManager.lastUse[worker.ID]; exists && now.Sub(lastUse) <= uploadManager.cooldown {
+			cooldownCount++
+			continue
 		}
+		availableCount++
 	}
 
 	return map[string]interface{}{
-		"totalWorkers":      len(uploadManager.workers),
-		"availableWorkers":   availableCount,
-		"cooldownWorkers":    cooldownCount,
-		"cooldownDuration":   uploadManager.cooldown.Seconds(),
+		"totalWorkers":         len(uploadManager.workers),
+		"availableWorkers":     availableCount,
+		"cooldownWorkers":      cooldownCount,
+		"floodWaitWorkers":     floodWaitCount,
+		"queuedUploads":        uploadManager.queued.Load(),
+		"cooldownDuration":     uploadManager.cooldown.Seconds(),
 		"uploadManagerEnabled": true,
 	}
 }
@@ -179,45 +270,110 @@ func (w *BotWorkers) AddDefaultClient(client *gotgproto.Client, self *tg.User) {
 		w.Bots = make([]*Worker, 0)
 	}
 	w.incStarting()
+	w.mut.Lock()
+	log := w.log
 	w.Bots = append(w.Bots, &Worker{
-		Client: client,
+		client: client,
 		ID:     w.starting,
+		Label:  "default",
+		proxy:  firstProxy(telegramProxies()),
 		Self:   self,
 		log:    w.log,
+		health: mainHealth,
+		load:   mainLoad,
+		opts:   mainClientOpts,
+		newClient: func() (*gotgproto.Client, error) {
+			client, _, err := startMainClient(log)
+			return client, err
+		},
 	})
+	w.mut.Unlock()
 	w.log.Sugar().Info("Default bot loaded")
 }
 
+// snapshot returns a copy of the current worker list.
+func (w *BotWorkers) snapshot() []*Worker {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	return append([]*Worker(nil), w.Bots...)
+}
+
 func (w *BotWorkers) incStarting() {
 	w.mut.Lock()
 	defer w.mut.Unlock()
 	w.starting++
 }
 
-func (w *BotWorkers) Add(token string) (err error) {
+func (w *BotWorkers) Add(token config.WorkerToken) (err error) {
 	w.incStarting()
 	var botID int = w.starting
-	client, err := startWorker(w.log, token, botID)
+	health := newWorkerHealth()
+	load := &workerLoad{}
+	client, opts, err := startWorker(w.log, token, botID, health, load)
 	if err != nil {
 		return err
 	}
-	w.log.Sugar().Infof("Bot @%s loaded with ID %d", client.Self.Username, botID)
+	if client.Self.ID != token.BotID() {
+		// the session file was copied from or left over by another bot
+		w.log.Warn("Session belongs to another bot, logging in again",
+			zap.String("worker", token.Label),
+			zap.Int64("sessionBotID", client.Self.ID),
+			zap.Int64("tokenBotID", token.BotID()))
+		client.Stop()
+		if err := removeSession(client, SessionPath(token.BotID())); err != nil {
+			return err
+		}
+		client, opts, err = startWorker(w.log, token, botID, health, load)
+		if err != nil {
+			return err
+		}
+		if client.Self.ID != token.BotID() {
+			client.Stop()
+			return fmt.Errorf("logged in as bot %d, expected %d", client.Self.ID, token.BotID())
+		}
+	}
+	w.log.Sugar().Infof("Bot @%s loaded as %s with ID %d", client.Self.Username, token.Label, botID)
+	w.mut.Lock()
+	log := w.log
 	w.Bots = append(w.Bots, &Worker{
-		Client: client,
+		client: client,
 		ID:     botID,
+		Label:  token.Label,
 		Self:   client.Self,
 		log:    w.log,
+		token:  token.Token,
+		proxy:  firstProxy(workerProxies(botID)),
+		health: health,
+		load:   load,
+		opts:   opts,
+		newClient: func() (*gotgproto.Client, error) {
+			client, _, err := startWorker(log, token, botID, health, load)
+			return client, err
+		},
 	})
+	w.mut.Unlock()
 	return nil
 }
 
+// GetNextWorker returns the next healthy worker in round-robin order. If no
+// worker is healthy it falls back to plain round-robin.
 func GetNextWorker() *Worker {
 	Workers.mut.Lock()
 	defer Workers.mut.Unlock()
-	index := (Workers.index + 1) % len(Workers.Bots)
+	total := len(Workers.Bots)
+	for i := 1; i <= total; i++ {
+		index := (Workers.index + i) % total
+		worker := Workers.Bots[index]
+		if worker.Healthy() {
+			Workers.index = index
+			Workers.log.Sugar().Debugf("Using worker %s", worker.Label)
+			return worker
+		}
+	}
+	index := (Workers.index + 1) % total
 	Workers.index = index
 	worker := Workers.Bots[index]
-	Workers.log.Sugar().Debugf("Using worker %d", worker.ID)
+	Workers.log.Sugar().Warnf("No healthy worker available, using worker %s", worker.Label)
 	return worker
 }
 
@@ -236,13 +392,40 @@ func StartWorkers(log *zap.Logger) (*BotWorkers, error) {
 			Workers.log.Error("Failed to create sessions directory", zap.Error(err))
 			return nil, err
 		}
+		migrateLegacySessions(config.ValueOf.MultiTokens)
+	}
+
+	totalBots := len(config.ValueOf.MultiTokens)
+	successfulStarts := startTokens(config.ValueOf.MultiTokens)
+	Workers.log.Sugar().Infof("Successfully started %d/%d bots", successfulStarts, totalBots)
+	return Workers, nil
+}
+
+// migrateLegacySessions renames the session files of older versions after
+// the bot they belong to, so the workers don't have to log in again.
+func migrateLegacySessions(tokens []config.WorkerToken) {
+	botIDs := make([]int64, 0, len(tokens))
+	for _, token := range tokens {
+		botIDs = append(botIDs, token.BotID())
 	}
+	migrations, err := MigrateLegacySessions(botIDs, false)
+	for _, migration := range migrations {
+		Workers.log.Info("Migrated legacy session file",
+			zap.String("from", migration.From),
+			zap.String("to", migration.To))
+	}
+	if err != nil {
+		Workers.log.Warn("Failed to migrate legacy session files", zap.Error(err))
+	}
+}
 
+// startTokens starts a worker for each token concurrently and returns how
+// many of them started.
+func startTokens(tokens []config.WorkerToken) int {
 	var wg sync.WaitGroup
 	var successfulStarts int32
-	totalBots := len(config.ValueOf.MultiTokens)
 
-	for i := 0; i < totalBots; i++ {
+	for i := 0; i < len(tokens); i++ {
 		wg.Add(1)
 		go func(i int) {
 			defer wg.Done()
@@ -252,49 +435,58 @@ func StartWorkers(log *zap.Logger) (*BotWorkers, error) {
 
 			done := make(chan error, 1)
 			go func() {
-				err := Workers.Add(config.ValueOf.MultiTokens[i])
+				err := Workers.Add(tokens[i])
 				done <- err
 			}()
 
 			select {
 			case err := <-done:
 				if err != nil {
-					Workers.log.Error("Failed to start worker", zap.Int("index", i), zap.Error(err))
+					Workers.log.Error("Failed to start worker", zap.String("worker", tokens[i].Label), zap.Error(err))
 				} else {
 					atomic.AddInt32(&successfulStarts, 1)
 				}
 			case <-ctx.Done():
-				Workers.log.Error("Timed out starting worker", zap.Int("index", i))
+				Workers.log.Error("Timed out starting worker", zap.String("worker", tokens[i].Label))
 			}
 		}(i)
 	}
 
 	wg.Wait() // Wait for all goroutines to finish
-	Workers.log.Sugar().Infof("Successfully started %d/%d bots", successfulStarts, totalBots)
-	return Workers, nil
+	return int(successfulStarts)
 }
 
-func startWorker(l *zap.Logger, botToken string, index int) (*gotgproto.Client, error) {
+func startWorker(l *zap.Logger, botToken config.WorkerToken, index int, health *workerHealth, load *workerLoad) (*gotgproto.Client, *gotgproto.ClientOpts, error) {
 	log := l.Named("Worker").Sugar()
-	log.Infof("Starting worker with index - %d", index)
+	log.Infof("Starting worker %s with index - %d", botToken.Label, index)
 	var sessionType sessionMaker.SessionConstructor
 	if config.ValueOf.UseSessionFile {
-		sessionType = sessionMaker.SqlSession(sqlite.Open(fmt.Sprintf("sessions/worker-%d.session", index)))
+		sessionType = sessionMaker.SqlSession(sqlite.Open(SessionPath(botToken.BotID())))
 	} else {
 		sessionType = sessionMaker.SimpleSession()
 	}
+	resolver, err := newResolver(log.Desugar(), workerProxies(index))
+	if err != nil {
+		return nil, nil, err
+	}
+	opts := &gotgproto.ClientOpts{
+		Session:          sessionType,
+		DisableCopyright: true,
+		Resolver:         resolver,
+		Middlewares:      append(GetFloodMiddleware(log.Desugar(), botToken.Label), health, load, getFileMetrics(botToken.Label), tracing.Middleware(botToken.Label)),
+	}
 	client, err := gotgproto.NewClient(
 		int(config.ValueOf.ApiID),
 		config.ValueOf.ApiHash,
-		gotgproto.ClientTypeBot(botToken),
-		&gotgproto.ClientOpts{
-			Session:          sessionType,
-			DisableCopyright: true,
-			Middlewares:      GetFloodMiddleware(log.Desugar()),
-		},
+		gotgproto.ClientTypeBot(botToken.Token),
+		opts,
 	)
 	if err != nil {
-		return nil, err
+		if client != nil {
+			// the client failed to start, its session database is still open
+			closeSession(client)
+		}
+		return nil, nil, err
 	}
-	return client, nil
+	return client, opts, nil
 }
@@ -0,0 +1,92 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func newTestUploadManager(workers ...*Worker) {
+	uploadManager = &UploadWorkerManager{
+		workers:      workers,
+		lastUse:      make(map[int]time.Time),
+		queueTimeout: time.Second,
+		turn:         make(chan struct{}, 1),
+		logger:       zap.NewNop(),
+	}
+}
+
+func newTestWorker(id int) *Worker {
+	return &Worker{ID: id, Label: "test", health: newWorkerHealth(), load: &workerLoad{}}
+}
+
+func TestAcquireUploadWorker_SkipsFloodWait(t *testing.T) {
+	first, second := newTestWorker(1), newTestWorker(2)
+	first.health.floodUntil = time.Now().Add(time.Minute)
+	newTestUploadManager(first, second)
+	defer func() { uploadManager = nil }()
+
+	for i := 0; i < 2; i++ {
+		worker, err := AcquireUploadWorker(context.Background(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if worker != second {
+			t.Fatalf("expected worker %d, got %d", second.ID, worker.ID)
+		}
+	}
+}
+
+func TestAcquireUploadWorker_PrefersUntried(t *testing.T) {
+	first, second := newTestWorker(1), newTestWorker(2)
+	newTestUploadManager(first, second)
+	defer func() { uploadManager = nil }()
+
+	worker, err := AcquireUploadWorker(context.Background(), map[int]bool{1: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if worker != second {
+		t.Fatalf("expected the untried worker %d, got %d", second.ID, worker.ID)
+	}
+	// with every worker tried, they can be used again
+	worker, err = AcquireUploadWorker(context.Background(), map[int]bool{1: true, 2: true})
+	if err != nil || worker == nil {
+		t.Fatalf("expected a worker, got %v", err)
+	}
+}
+
+func TestAcquireUploadWorker_QueuesUntilFloodWaitEnds(t *testing.T) {
+	worker := newTestWorker(1)
+	worker.health.floodUntil = time.Now().Add(300 * time.Millisecond)
+	newTestUploadManager(worker)
+	defer func() { uploadManager = nil }()
+
+	start := time.Now()
+	got, err := AcquireUploadWorker(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != worker {
+		t.Fatalf("expected worker %d, got %d", worker.ID, got.ID)
+	}
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Fatalf("upload was not queued (returned after %s)", elapsed)
+	}
+}
+
+func TestAcquireUploadWorker_QueueTimeout(t *testing.T) {
+	worker := newTestWorker(1)
+	worker.health.floodUntil = time.Now().Add(time.Minute)
+	newTestUploadManager(worker)
+	uploadManager.queueTimeout = 200 * time.Millisecond
+	defer func() { uploadManager = nil }()
+
+	_, err := AcquireUploadWorker(context.Background(), nil)
+	if !errors.Is(err, ErrNoUploadWorker) {
+		t.Fatalf("expected ErrNoUploadWorker, got %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"EverythingSuckz/fsb/config"
 	"EverythingSuckz/fsb/internal/types"
 	"bytes"
 	"encoding/gob"
@@ -25,7 +26,11 @@ func InitCache(log *zap.Logger) {
 	gob.Register(tg.InputDocumentFileLocation{})
 	gob.Register(tg.InputPhotoFileLocation{})
 	defer log.Sugar().Info("Initialized")
-	cache = &Cache{cache: freecache.NewCache(10 * 1024 * 1024), log: log}
+	size := config.ValueOf.CacheSize
+	if size < 1 {
+		size = 10
+	}
+	cache = &Cache{cache: freecache.NewCache(size * 1024 * 1024), log: log}
 }
 
 func GetCache() *Cache {
@@ -33,6 +38,16 @@ func GetCache() *Cache {
 }
 
 func (c *Cache) Get(key string, value *types.File) error {
+	return c.GetValue(key, value)
+}
+
+func (c *Cache) Set(key string, value *types.File, expireSeconds int) error {
+	return c.SetValue(key, value, expireSeconds)
+}
+
+// GetValue decodes the cached value of key into value, which must be a
+// pointer.
+func (c *Cache) GetValue(key string, value any) error {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 	data, err := cache.cache.Get([]byte(key))
@@ -40,14 +55,15 @@ func (c *Cache) Get(key string, value *types.File) error {
 		return err
 	}
 	dec := gob.NewDecoder(bytes.NewReader(data))
-	err = dec.Decode(&value)
+	err = dec.Decode(value)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (c *Cache) Set(key string, value *types.File, expireSeconds int) error {
+// SetValue caches any gob encodable value.
+func (c *Cache) SetValue(key string, value any, expireSeconds int) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	var buf bytes.Buffer
@@ -66,3 +82,26 @@ func (c *Cache) Delete(key string) error {
 	cache.cache.Del([]byte(key))
 	return nil
 }
+
+// Stats are the counters of the file cache.
+type Stats struct {
+	Hits      int64
+	Misses    int64
+	Evictions int64
+	Expired   int64
+	Entries   int64
+}
+
+// GetStats returns the counters of the file cache, all zero before InitCache.
+func GetStats() Stats {
+	if cache == nil {
+		return Stats{}
+	}
+	return Stats{
+		Hits:      cache.cache.HitCount(),
+		Misses:    cache.cache.MissCount(),
+		Evictions: cache.cache.EvacuateCount(),
+		Expired:   cache.cache.ExpiredCount(),
+		Entries:   cache.cache.EntryCount(),
+	}
+}
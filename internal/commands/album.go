@@ -0,0 +1,179 @@
+package commands
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"EverythingSuckz/fsb/config"
+	fsbtypes "EverythingSuckz/fsb/internal/types"
+	"EverythingSuckz/fsb/internal/utils"
+
+	"github.com/celestix/gotgproto/ext"
+	"github.com/gotd/td/telegram/message/styling"
+	"github.com/gotd/td/telegram/uploader"
+	"github.com/gotd/td/tg"
+	"go.uber.org/zap"
+)
+
+// albumWindow is how long we wait for the remaining messages of a media
+// group after the last one arrived before handling the whole album.
+var albumWindow = 1500 * time.Millisecond
+
+type albumKey struct {
+	chatID    int64
+	groupedID int64
+}
+
+type pendingAlbum struct {
+	ctx        *ext.Context
+	update     *ext.Update
+	messageIDs []int
+	timer      *time.Timer
+}
+
+var (
+	albumsMut sync.Mutex
+	albums    = make(map[albumKey]*pendingAlbum)
+	// onAlbum handles a complete album, tests replace it.
+	onAlbum = sendAlbumLinks
+)
+
+func queueAlbumMessage(ctx *ext.Context, u *ext.Update, chatID int64, groupedID int64) {
+	key := albumKey{chatID: chatID, groupedID: groupedID}
+	albumsMut.Lock()
+	defer albumsMut.Unlock()
+	if album, ok := albums[key]; ok {
+		album.messageIDs = append(album.messageIDs, u.EffectiveMessage.ID)
+		album.timer.Reset(albumWindow)
+		return
+	}
+	albums[key] = &pendingAlbum{
+		ctx:        ctx,
+		update:     u,
+		messageIDs: []int{u.EffectiveMessage.ID},
+		timer:      time.AfterFunc(albumWindow, func() { flushAlbum(key) }),
+	}
+}
+
+func flushAlbum(key albumKey) {
+	albumsMut.Lock()
+	album, ok := albums[key]
+	delete(albums, key)
+	albumsMut.Unlock()
+	if ok {
+		onAlbum(key, album)
+	}
+}
+
+func sendAlbumLinks(key albumKey, album *pendingAlbum) {
+	log := utils.Logger.Named("album")
+	ctx, u := album.ctx, album.update
+	sort.Ints(album.messageIDs)
+	log.Debug("Forwarding album", zap.Int64("groupedID", key.groupedID), zap.Ints("messageIDs", album.messageIDs))
+	update, err := utils.ForwardMessages(ctx, key.chatID, config.ValueOf.LogChannelID, album.messageIDs...)
+	if err != nil {
+		log.Error("Failed to forward album", zap.Error(err))
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return
+	}
+	var (
+		items    []albumItem
+		playlist strings.Builder
+		plain    strings.Builder
+		m3u      = true
+	)
+	playlist.WriteString("#EXTM3U\n")
+	forwarded := utils.ForwardedMessages(update)
+	for i, msg := range forwarded {
+		file, err := utils.FileFromMedia(msg.Media)
+		if err != nil {
+			log.Warn("Skipping unsupported album item", zap.Int("messageID", msg.ID), zap.Error(err))
+			continue
+		}
+		item := albumItem{messageID: msg.ID, file: file, name: file.FileName, link: streamLink(msg.ID, file)}
+		// the forwarded messages can only be matched with the user's ones if
+		// none is missing
+		if len(forwarded) == len(album.messageIDs) {
+			item.userMessageID = album.messageIDs[i]
+		}
+		items = append(items, item)
+		fmt.Fprintf(&playlist, "#EXTINF:-1,%s\n%s\n", item.name, item.link)
+		fmt.Fprintf(&plain, "%s\n%s\n\n", item.name, item.link)
+		if !strings.Contains(file.MimeType, "video") && !strings.Contains(file.MimeType, "audio") {
+			m3u = false
+		}
+	}
+	if len(items) == 0 {
+		ctx.Reply(u, "Sorry, none of the files in this album are supported.", nil)
+		return
+	}
+	reply, err := ctx.Reply(u, albumText(items), &ext.ReplyOpts{
+		NoWebpage:        true,
+		ReplyToMessageId: album.messageIDs[0],
+	})
+	if err != nil {
+		log.Error("Failed to reply with album links", zap.Error(err))
+		return
+	}
+	for _, item := range items {
+		if item.userMessageID != 0 {
+			saveLink(key.chatID, item.userMessageID, reply.ID, item.messageID, item.file)
+		}
+	}
+	if !config.ValueOf.AlbumLinksFile {
+		return
+	}
+	fileName, content, mimeType := fmt.Sprintf("album_%d.txt", key.groupedID), plain.String(), "text/plain"
+	if m3u {
+		fileName, content, mimeType = fmt.Sprintf("album_%d.m3u", key.groupedID), playlist.String(), "audio/x-mpegurl"
+	}
+	if err := sendLinksFile(ctx, key.chatID, album.messageIDs[0], fileName, mimeType, []byte(content)); err != nil {
+		log.Error("Failed to send album links file", zap.Error(err))
+	}
+}
+
+// albumItem is a file of an album along with its link.
+type albumItem struct {
+	messageID     int
+	userMessageID int
+	file          *fsbtypes.File
+	name          string
+	link          string
+	expiresAt     int64
+}
+
+// albumText lists the links of an album in one reply.
+func albumText(items []albumItem) []styling.StyledTextOption {
+	var text []styling.StyledTextOption
+	for i, item := range items {
+		if i > 0 {
+			text = append(text, styling.Plain("\n\n"))
+		}
+		text = append(text, styling.Bold(fmt.Sprintf("%d. %s", i+1, item.name)), styling.Plain("\n"), styling.Code(item.link))
+		if item.expiresAt != 0 {
+			text = append(text, styling.Plain("\nExpires: "+expiryTime(item.expiresAt)))
+		}
+	}
+	return text
+}
+
+func sendLinksFile(ctx *ext.Context, chatID int64, replyTo int, fileName string, mimeType string, content []byte) error {
+	upload, err := uploader.NewUploader(ctx.Raw).FromBytes(ctx, fileName, content)
+	if err != nil {
+		return err
+	}
+	_, err = ctx.SendMedia(chatID, &tg.MessagesSendMediaRequest{
+		ReplyTo: &tg.InputReplyToMessage{ReplyToMsgID: replyTo},
+		Media: &tg.InputMediaUploadedDocument{
+			File:     upload,
+			MimeType: mimeType,
+			Attributes: []tg.DocumentAttributeClass{
+				&tg.DocumentAttributeFilename{FileName: fileName},
+			},
+		},
+	})
+	return err
+}
@@ -0,0 +1,85 @@
+package commands
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/types"
+	"github.com/gotd/td/tg"
+)
+
+type flushedAlbum struct {
+	key        albumKey
+	messageIDs []int
+}
+
+func catchAlbums(t *testing.T, window time.Duration) chan flushedAlbum {
+	flushed := make(chan flushedAlbum, 4)
+	oldWindow, oldHandler := albumWindow, onAlbum
+	albumWindow = window
+	onAlbum = func(key albumKey, album *pendingAlbum) {
+		flushed <- flushedAlbum{key, album.messageIDs}
+	}
+	t.Cleanup(func() { albumWindow, onAlbum = oldWindow, oldHandler })
+	return flushed
+}
+
+func albumUpdate(id int) *ext.Update {
+	return &ext.Update{EffectiveMessage: &types.Message{Message: &tg.Message{ID: id}}}
+}
+
+func TestAlbumGrouping(t *testing.T) {
+	flushed := catchAlbums(t, 50*time.Millisecond)
+	queueAlbumMessage(nil, albumUpdate(1), 10, 100)
+	queueAlbumMessage(nil, albumUpdate(2), 10, 200)
+	queueAlbumMessage(nil, albumUpdate(3), 10, 100)
+	queueAlbumMessage(nil, albumUpdate(4), 20, 100)
+
+	got := map[albumKey][]int{}
+	for i := 0; i < 3; i++ {
+		select {
+		case album := <-flushed:
+			got[album.key] = album.messageIDs
+		case <-time.After(time.Second):
+			t.Fatalf("only %d albums flushed", i)
+		}
+	}
+	want := map[albumKey][]int{
+		{chatID: 10, groupedID: 100}: {1, 3},
+		{chatID: 10, groupedID: 200}: {2},
+		{chatID: 20, groupedID: 100}: {4},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got albums %v, want %v", got, want)
+	}
+}
+
+// Every message of the album restarts the window, so slow albums are
+// still handled once.
+func TestAlbumTimerReset(t *testing.T) {
+	window := 100 * time.Millisecond
+	flushed := catchAlbums(t, window)
+	start := time.Now()
+	for id := 1; id <= 4; id++ {
+		queueAlbumMessage(nil, albumUpdate(id), 10, 300)
+		time.Sleep(window / 2)
+	}
+	select {
+	case album := <-flushed:
+		if !reflect.DeepEqual(album.messageIDs, []int{1, 2, 3, 4}) {
+			t.Errorf("album flushed with %v", album.messageIDs)
+		}
+		if elapsed := time.Since(start); elapsed < 2*window {
+			t.Errorf("album flushed after %s, before the window of the last message ended", elapsed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("album never flushed")
+	}
+	select {
+	case album := <-flushed:
+		t.Errorf("album flushed twice, again with %v", album.messageIDs)
+	case <-time.After(2 * window):
+	}
+}
@@ -0,0 +1,196 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/links"
+	"EverythingSuckz/fsb/internal/utils"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/storage"
+	"github.com/gotd/td/telegram/message/entity"
+	"github.com/gotd/td/telegram/message/styling"
+	"github.com/gotd/td/tg"
+	"go.uber.org/zap"
+)
+
+func (m *command) LoadLinkOptions(dispatcher dispatcher.Dispatcher) {
+	log := m.log.Named("options")
+	defer log.Sugar().Info("Loaded")
+	dispatcher.AddHandler(handlers.NewCommand("rename", renameLink))
+	dispatcher.AddHandler(handlers.NewCommand("expire", expireLink))
+}
+
+func renameLink(ctx *ext.Context, u *ext.Update) error {
+	link, ok := repliedLink(ctx, u, "/rename new_name.mkv")
+	if !ok {
+		return dispatcher.EndGroups
+	}
+	name := utils.SanitizeFilename(commandArgument(u.EffectiveMessage.Text))
+	if name == "" {
+		ctx.Reply(u, "Usage: reply to your file with /rename new_name.mkv", nil)
+		return dispatcher.EndGroups
+	}
+	if name == link.OriginalName {
+		name = ""
+	}
+	link.FileName = name
+	return updateLink(ctx, u, link)
+}
+
+func expireLink(ctx *ext.Context, u *ext.Update) error {
+	link, ok := repliedLink(ctx, u, "/expire 24h")
+	if !ok {
+		return dispatcher.EndGroups
+	}
+	duration, err := parseExpiry(commandArgument(u.EffectiveMessage.Text))
+	if err != nil {
+		ctx.Reply(u, fmt.Sprintf("Error - %s\nUsage: reply to your file with /expire 24h, /expire 7d or /expire off", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	if duration == 0 {
+		link.ExpiresAt = 0
+	} else {
+		link.ExpiresAt = time.Now().Add(duration).Unix()
+	}
+	return updateLink(ctx, u, link)
+}
+
+// repliedLink returns the link generated for the file the command replies to.
+func repliedLink(ctx *ext.Context, u *ext.Update, usage string) (*links.Link, bool) {
+	chatId := u.EffectiveChat().GetID()
+	peerChatId := ctx.PeerStorage.GetPeerById(chatId)
+	if peerChatId.Type != int(storage.TypeUser) {
+		return nil, false
+	}
+	if len(config.ValueOf.AllowedUsers) != 0 && !utils.Contains(config.ValueOf.AllowedUsers, chatId) {
+		ctx.Reply(u, "You are not allowed to use this bot.", nil)
+		return nil, false
+	}
+	replyTo, ok := u.EffectiveMessage.ReplyTo.(*tg.MessageReplyHeader)
+	if !ok || replyTo.ReplyToMsgID == 0 {
+		ctx.Reply(u, fmt.Sprintf("Reply to a file you sent me with %s", usage), nil)
+		return nil, false
+	}
+	link, err := links.GetByUserMessage(chatId, replyTo.ReplyToMsgID)
+	if err != nil {
+		if !errors.Is(err, links.ErrNotFound) {
+			utils.Logger.Named("links").Error("Failed to get link", zap.Error(err))
+		}
+		ctx.Reply(u, "I don't have a link for that message, send me the file first.", nil)
+		return nil, false
+	}
+	return link, true
+}
+
+// updateLink stores the new options of a link and edits the bot's previous
+// reply to show the new link, or sends a new reply if it can't be edited.
+func updateLink(ctx *ext.Context, u *ext.Update, link *links.Link) error {
+	if err := links.Save(link); err != nil {
+		utils.Logger.Sugar().Error(err)
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	url := customLink(link)
+	text := []styling.StyledTextOption{styling.Code(url)}
+	if link.FileName != "" {
+		text = append(text, styling.Plain("\nName: "), styling.Code(link.FileName))
+	}
+	if link.ExpiresAt != 0 {
+		text = append(text, styling.Plain("\nExpires: "+expiryTime(link.ExpiresAt)))
+	}
+	markup := linkMarkup(url, link.MimeType)
+	if link.ReplyID != 0 {
+		replyText, replyMarkup, noWebpage := text, markup, false
+		// the reply of an album lists the links of all its files
+		album, err := links.GetByReply(link.ChatID, link.ReplyID)
+		if err != nil {
+			utils.Logger.Named("links").Warn("Failed to get album links", zap.Int("replyID", link.ReplyID), zap.Error(err))
+		} else if len(album) > 1 {
+			replyText, replyMarkup, noWebpage = albumText(albumItems(album)), nil, true
+		}
+		tb := entity.Builder{}
+		if err := styling.Perform(&tb, replyText...); err != nil {
+			return err
+		}
+		message, entities := tb.Complete()
+		_, err = ctx.EditMessage(u.EffectiveChat().GetID(), &tg.MessagesEditMessageRequest{
+			ID:          link.ReplyID,
+			NoWebpage:   noWebpage,
+			Message:     message,
+			Entities:    entities,
+			ReplyMarkup: replyMarkup,
+		})
+		if err == nil {
+			ctx.Reply(u, "Link updated.", &ext.ReplyOpts{ReplyToMessageId: link.ReplyID})
+			return dispatcher.EndGroups
+		}
+		utils.Logger.Named("links").Warn("Failed to edit link reply", zap.Int("replyID", link.ReplyID), zap.Error(err))
+	}
+	_, err := ctx.Reply(u, text, &ext.ReplyOpts{
+		Markup:           markup,
+		ReplyToMessageId: link.UserMessageID,
+	})
+	if err != nil {
+		utils.Logger.Sugar().Error(err)
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+	}
+	return dispatcher.EndGroups
+}
+
+// albumItems returns the items of an album reply from its stored links.
+func albumItems(album []links.Link) []albumItem {
+	items := make([]albumItem, len(album))
+	for i := range album {
+		items[i] = albumItem{
+			messageID:     album[i].MessageID,
+			userMessageID: album[i].UserMessageID,
+			name:          album[i].ServedName(),
+			link:          customLink(&album[i]),
+			expiresAt:     album[i].ExpiresAt,
+		}
+	}
+	return items
+}
+
+func expiryTime(expiresAt int64) string {
+	return time.Unix(expiresAt, 0).UTC().Format("2006-01-02 15:04 MST")
+}
+
+// commandArgument returns everything after the command in a message.
+func commandArgument(text string) string {
+	fields := strings.SplitN(strings.TrimSpace(text), " ", 2)
+	if len(fields) < 2 {
+		return ""
+	}
+	return strings.TrimSpace(fields[1])
+}
+
+// parseExpiry parses durations like 90m, 24h or 7d. "off" removes the expiry.
+func parseExpiry(value string) (time.Duration, error) {
+	switch strings.ToLower(value) {
+	case "":
+		return 0, errors.New("missing duration")
+	case "off", "never", "0":
+		return 0, nil
+	}
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	return duration, nil
+}
@@ -0,0 +1,47 @@
+package commands
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseExpiry(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"90m", 90 * time.Minute, false},
+		{"24h", 24 * time.Hour, false},
+		{"1h30m", 90 * time.Minute, false},
+		{"7d", 7 * 24 * time.Hour, false},
+		{"off", 0, false},
+		{"Never", 0, false},
+		{"0", 0, false},
+		{"", 0, true},
+		{"0d", 0, true},
+		{"-2h", 0, true},
+		{"-1d", 0, true},
+		{"1.5d", 0, true},
+		{"soon", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseExpiry(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseExpiry(%q) = %s, %v, want %s", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestCommandArgument(t *testing.T) {
+	tests := map[string]string{
+		"/rename new name.mkv": "new name.mkv",
+		"/expire   24h ":       "24h",
+		"/rename":              "",
+	}
+	for text, want := range tests {
+		if got := commandArgument(text); got != want {
+			t.Errorf("commandArgument(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"strings"
 
 	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/links"
+	fsbtypes "EverythingSuckz/fsb/internal/types"
 	"EverythingSuckz/fsb/internal/utils"
 
 	"github.com/celestix/gotgproto/dispatcher"
@@ -14,6 +16,7 @@ import (
 	"github.com/celestix/gotgproto/types"
 	"github.com/gotd/td/telegram/message/styling"
 	"github.com/gotd/td/tg"
+	"go.uber.org/zap"
 )
 
 func (m *command) LoadStream(dispatcher dispatcher.Dispatcher) {
@@ -33,6 +36,11 @@ func supportedMediaFilter(m *types.Message) (bool, error) {
 		return true, nil
 	case *tg.MessageMediaPhoto:
 		return true, nil
+	case *tg.MessageMediaWebPage:
+		if _, ok := utils.WebPageMedia(m.Media.(*tg.MessageMediaWebPage)); ok {
+			return true, nil
+		}
+		return false, dispatcher.EndGroups
 	case tg.MessageMediaClass:
 		return false, dispatcher.EndGroups
 	default:
@@ -58,6 +66,10 @@ func sendLink(ctx *ext.Context, u *ext.Update) error {
 		ctx.Reply(u, "Sorry, this message type is unsupported.", nil)
 		return dispatcher.EndGroups
 	}
+	if groupedID, ok := u.EffectiveMessage.GetGroupedID(); ok {
+		queueAlbumMessage(ctx, u, chatId, groupedID)
+		return dispatcher.EndGroups
+	}
 	update, err := utils.ForwardMessages(ctx, chatId, config.ValueOf.LogChannelID, u.EffectiveMessage.ID)
 	if err != nil {
 		utils.Logger.Sugar().Error(err)
@@ -71,15 +83,28 @@ func sendLink(ctx *ext.Context, u *ext.Update) error {
 		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
 		return dispatcher.EndGroups
 	}
-	fullHash := utils.PackFile(
-		file.FileName,
-		file.FileSize,
-		file.MimeType,
-		file.ID,
-	)
-	hash := utils.GetShortHash(fullHash)
-	link := fmt.Sprintf("%s/stream/%d?hash=%s", config.ValueOf.Host, messageID, hash)
+	link := streamLink(messageID, file)
 	text := []styling.StyledTextOption{styling.Code(link)}
+	reply, err := ctx.Reply(u, text, &ext.ReplyOpts{
+		Markup:           linkMarkup(link, file.MimeType),
+		NoWebpage:        false,
+		ReplyToMessageId: u.EffectiveMessage.ID,
+	})
+	if err != nil {
+		utils.Logger.Sugar().Error(err)
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	saveLink(chatId, u.EffectiveMessage.ID, reply.ID, messageID, file)
+	return dispatcher.EndGroups
+}
+
+// linkMarkup returns the Download/Stream buttons for a link, or nil for
+// localhost links since Telegram rejects those URLs in buttons.
+func linkMarkup(link string, mimeType string) tg.ReplyMarkupClass {
+	if strings.Contains(link, "http://localhost") {
+		return nil
+	}
 	row := tg.KeyboardButtonRow{
 		Buttons: []tg.KeyboardButtonClass{
 			&tg.KeyboardButtonURL{
@@ -88,30 +113,55 @@ func sendLink(ctx *ext.Context, u *ext.Update) error {
 			},
 		},
 	}
-	if strings.Contains(file.MimeType, "video") || strings.Contains(file.MimeType, "audio") || strings.Contains(file.MimeType, "pdf") {
+	if strings.Contains(mimeType, "video") || strings.Contains(mimeType, "audio") || strings.Contains(mimeType, "pdf") {
 		row.Buttons = append(row.Buttons, &tg.KeyboardButtonURL{
 			Text: "Stream",
 			URL:  link,
 		})
 	}
-	markup := &tg.ReplyInlineMarkup{
+	return &tg.ReplyInlineMarkup{
 		Rows: []tg.KeyboardButtonRow{row},
 	}
-	if strings.Contains(link, "http://localhost") {
-		_, err = ctx.Reply(u, text, &ext.ReplyOpts{
-			NoWebpage:        false,
-			ReplyToMessageId: u.EffectiveMessage.ID,
-		})
-	} else {
-		_, err = ctx.Reply(u, text, &ext.ReplyOpts{
-			Markup:           markup,
-			NoWebpage:        false,
-			ReplyToMessageId: u.EffectiveMessage.ID,
-		})
-	}
+}
+
+// saveLink remembers which log channel message a user's file was forwarded
+// to, so that /rename and /expire can later be used on it.
+func saveLink(chatID int64, userMessageID int, replyID int, messageID int, file *fsbtypes.File) {
+	err := links.Save(&links.Link{
+		MessageID:     messageID,
+		ChatID:        chatID,
+		UserMessageID: userMessageID,
+		ReplyID:       replyID,
+		FileID:        file.ID,
+		FileSize:      file.FileSize,
+		MimeType:      file.MimeType,
+		OriginalName:  file.FileName,
+	})
 	if err != nil {
-		utils.Logger.Sugar().Error(err)
-		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		utils.Logger.Named("links").Warn("Failed to save link", zap.Int("messageID", messageID), zap.Error(err))
 	}
-	return dispatcher.EndGroups
+}
+
+func streamLink(messageID int, file *fsbtypes.File) string {
+	fullHash := utils.PackFile(
+		file.FileName,
+		file.FileSize,
+		file.MimeType,
+		file.ID,
+	)
+	hash := utils.GetShortHash(fullHash)
+	return fmt.Sprintf("%s/stream/%d?hash=%s", config.ValueOf.Host, messageID, hash)
+}
+
+// customLink returns the link of a file that was renamed or given an expiry.
+func customLink(link *links.Link) string {
+	fullHash := utils.PackLink(
+		link.ServedName(),
+		link.FileSize,
+		link.MimeType,
+		link.FileID,
+		link.ExpiresAt,
+	)
+	hash := utils.GetShortHash(fullHash)
+	return fmt.Sprintf("%s/stream/%d?hash=%s", config.ValueOf.Host, link.MessageID, hash)
 }
@@ -0,0 +1,177 @@
+// Package links keeps track of the links generated by the bot along with the
+// options users attached to them (custom file name, expiry).
+package links
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/cache"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// ErrNotFound is returned when no link is stored for the given message.
+var ErrNotFound = errors.New("link not found")
+
+// Link is a file forwarded to the log channel by a user.
+type Link struct {
+	// MessageID is the ID of the message in the log channel.
+	MessageID int `gorm:"primaryKey;autoIncrement:false"`
+	// ChatID and UserMessageID identify the message the user sent to the bot.
+	ChatID        int64 `gorm:"index:idx_user_message"`
+	UserMessageID int   `gorm:"index:idx_user_message"`
+	// ReplyID is the bot's reply holding the link, shared by the items of an
+	// album, 0 if it can't be edited.
+	ReplyID int
+
+	FileID   int64
+	FileSize int64
+	MimeType string
+	// OriginalName is the name of the file on Telegram.
+	OriginalName string
+	// FileName is the custom served name, empty if not renamed.
+	FileName string
+	// ExpiresAt is the unix time after which the custom link stops working, 0 for never.
+	ExpiresAt int64
+}
+
+// HasOptions reports whether the link was renamed or given an expiry.
+func (l *Link) HasOptions() bool {
+	return l.FileName != "" || l.ExpiresAt != 0
+}
+
+// ServedName returns the file name the custom link is served with.
+func (l *Link) ServedName() string {
+	if l.FileName != "" {
+		return l.FileName
+	}
+	return l.OriginalName
+}
+
+// Expired reports whether the custom link has expired.
+func (l *Link) Expired() bool {
+	return l.ExpiresAt != 0 && time.Now().Unix() > l.ExpiresAt
+}
+
+var (
+	db  *gorm.DB
+	mut sync.Mutex
+)
+
+func Init(log *zap.Logger, path string) error {
+	log = log.Named("links")
+	conn, err := gorm.Open(sqlite.Open(path), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		return err
+	}
+	if err := conn.AutoMigrate(&Link{}); err != nil {
+		return err
+	}
+	mut.Lock()
+	db = conn
+	mut.Unlock()
+	log.Sugar().Infof("Initialized (%s)", path)
+	return nil
+}
+
+func getDB() (*gorm.DB, error) {
+	mut.Lock()
+	defer mut.Unlock()
+	if db == nil {
+		return nil, errors.New("links store is not initialized")
+	}
+	return db, nil
+}
+
+// Save inserts or updates a link.
+func Save(link *Link) error {
+	conn, err := getDB()
+	if err != nil {
+		return err
+	}
+	if err := conn.Save(link).Error; err != nil {
+		return err
+	}
+	cacheLink(link.MessageID, link)
+	return nil
+}
+
+// Get returns the link stored for a log channel message.
+func Get(messageID int) (*Link, error) {
+	var link Link
+	if c := cache.GetCache(); c != nil && c.GetValue(cacheKey(messageID), &link) == nil {
+		if link.MessageID == 0 {
+			return nil, ErrNotFound
+		}
+		return &link, nil
+	}
+	conn, err := getDB()
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	err = conn.First(&link, "message_id = ?", messageID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		cacheLink(messageID, &Link{})
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	cacheLink(messageID, &link)
+	return &link, nil
+}
+
+// cacheKey is the key of the link of a message in the file info cache.
+// Every range request of a stream checks the link of its message, so links
+// and the messages without one are cached next to the file info instead of
+// being read from SQLite each time.
+func cacheKey(messageID int) string {
+	return fmt.Sprintf("link:%d", messageID)
+}
+
+// cacheLink caches the link of a message, an empty link meaning none.
+func cacheLink(messageID int, link *Link) {
+	if c := cache.GetCache(); c != nil {
+		c.SetValue(cacheKey(messageID), link, config.ValueOf.CacheTTL)
+	}
+}
+
+// GetByUserMessage returns the link generated for a message a user sent to the bot.
+func GetByUserMessage(chatID int64, userMessageID int) (*Link, error) {
+	conn, err := getDB()
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	var link Link
+	err = conn.First(&link, "chat_id = ? AND user_message_id = ?", chatID, userMessageID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// GetByReply returns the links listed in one reply of the bot, which holds
+// several links for albums, in the order the user sent the files.
+func GetByReply(chatID int64, replyID int) ([]Link, error) {
+	conn, err := getDB()
+	if err != nil {
+		return nil, err
+	}
+	var found []Link
+	err = conn.Order("user_message_id").Find(&found, "chat_id = ? AND reply_id = ?", chatID, replyID).Error
+	if err != nil {
+		return nil, err
+	}
+	return found, nil
+}
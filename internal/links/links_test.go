@@ -0,0 +1,53 @@
+package links
+
+import (
+	"EverythingSuckz/fsb/internal/cache"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// Links are read from SQLite once and then served from the cache, which Save
+// keeps up to date.
+func TestGetCached(t *testing.T) {
+	cache.InitCache(zap.NewNop())
+	if err := Init(zap.NewNop(), filepath.Join(t.TempDir(), "links.db")); err != nil {
+		t.Fatal(err)
+	}
+	if err := Save(&Link{MessageID: 1, FileID: 10, FileName: "renamed.mkv"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Get(2); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	// changes made behind the back of Save aren't seen
+	if err := db.Create(&Link{MessageID: 2, FileID: 20}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Model(&Link{}).Where("message_id = ?", 1).Update("file_name", "other.mkv").Error; err != nil {
+		t.Fatal(err)
+	}
+	link, err := Get(1)
+	if err != nil || link.FileName != "renamed.mkv" {
+		t.Errorf("got %+v, %v, want the cached link", link, err)
+	}
+	if _, err := Get(2); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected the cached ErrNotFound, got %v", err)
+	}
+
+	if err := Save(&Link{MessageID: 1, FileID: 10, FileName: "new.mkv", ExpiresAt: 100}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Save(&Link{MessageID: 2, FileID: 20, FileName: "second.mkv"}); err != nil {
+		t.Fatal(err)
+	}
+	if link, err := Get(1); err != nil || link.FileName != "new.mkv" || link.ExpiresAt != 100 {
+		t.Errorf("got %+v, %v after Save", link, err)
+	}
+	if link, err := Get(2); err != nil || link.FileName != "second.mkv" {
+		t.Errorf("got %+v, %v after Save", link, err)
+	}
+}
@@ -0,0 +1,139 @@
+package metrics
+
+import (
+	"EverythingSuckz/fsb/internal/cache"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "fsb"
+
+// Registry holds every metric exposed on /metrics.
+var Registry = prometheus.NewRegistry()
+
+var (
+	StreamRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "stream",
+		Name:      "requests_total",
+		Help:      "Stream requests by HTTP status.",
+	}, []string{"status"})
+	StreamBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "stream",
+		Name:      "bytes_total",
+		Help:      "Bytes sent to stream clients.",
+	})
+	StreamDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "stream",
+		Name:      "duration_seconds",
+		Help:      "Time taken to serve stream requests by HTTP status.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 14),
+	}, []string{"status"})
+	ActiveStreams = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "stream",
+		Name:      "active",
+		Help:      "Streams being served.",
+	})
+
+	GetFileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "telegram",
+		Name:      "get_file_duration_seconds",
+		Help:      "Latency of upload.getFile calls by worker.",
+	}, []string{"worker"})
+	GetFileErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "telegram",
+		Name:      "get_file_errors_total",
+		Help:      "Failed upload.getFile calls by worker.",
+	}, []string{"worker"})
+	FloodWaits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "telegram",
+		Name:      "flood_waits_total",
+		Help:      "FLOOD_WAIT errors returned by Telegram by worker.",
+	}, []string{"worker"})
+	FloodWaitSeconds = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "telegram",
+		Name:      "flood_wait_seconds_total",
+		Help:      "Seconds Telegram asked to wait in FLOOD_WAIT errors by worker.",
+	}, []string{"worker"})
+
+	Uploads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "upload",
+		Name:      "requests_total",
+		Help:      "Uploads by result: success, failed or rate_limited.",
+	}, []string{"result"})
+	UploadBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "upload",
+		Name:      "bytes_total",
+		Help:      "Bytes of successful uploads.",
+	})
+	QuotaUsedBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "upload",
+		Name:      "quota_used_bytes",
+		Help:      "Upload quota used by all users since the start.",
+	})
+	QuotaLimitBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "upload",
+		Name:      "quota_limit_bytes",
+		Help:      "Upload quota of each user, 0 if unlimited.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		StreamRequests, StreamBytes, StreamDuration, ActiveStreams,
+		GetFileDuration, GetFileErrors, FloodWaits, FloodWaitSeconds,
+		Uploads, UploadBytes, QuotaUsedBytes, QuotaLimitBytes,
+		cacheCounter("hits_total", "File cache hits.", func(s cache.Stats) int64 { return s.Hits }),
+		cacheCounter("misses_total", "File cache misses.", func(s cache.Stats) int64 { return s.Misses }),
+		cacheCounter("evictions_total", "File cache entries evicted to make room.", func(s cache.Stats) int64 { return s.Evictions }),
+		cacheCounter("expired_total", "File cache entries expired.", func(s cache.Stats) int64 { return s.Expired }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "cache",
+			Name:      "entries",
+			Help:      "File cache entries.",
+		}, func() float64 { return float64(cache.GetStats().Entries) }),
+	)
+}
+
+func cacheCounter(name, help string, value func(cache.Stats) int64) prometheus.Collector {
+	return prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "cache",
+		Name:      name,
+		Help:      help,
+	}, func() float64 { return float64(value(cache.GetStats())) })
+}
+
+// ObserveStream records a finished stream request.
+func ObserveStream(status int, bytes int64, start time.Time) {
+	label := strconv.Itoa(status)
+	StreamRequests.WithLabelValues(label).Inc()
+	StreamDuration.WithLabelValues(label).Observe(time.Since(start).Seconds())
+	if bytes > 0 {
+		StreamBytes.Add(float64(bytes))
+	}
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,77 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/utils"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const accessEntryKey = "accessEntry"
+
+// accessEntry holds what a handler knows about the request for the access
+// log.
+type accessEntry struct {
+	MessageID  int
+	FileName   string
+	FileSize   int64
+	Range      string
+	RangeStart int64
+	RangeEnd   int64
+	Files      int
+	Worker     *bot.Worker
+}
+
+// accessLog writes an access log entry once the request is served.
+func accessLog(ctx *gin.Context) {
+	start := time.Now()
+	entry := &accessEntry{RangeEnd: -1}
+	ctx.Set(accessEntryKey, entry)
+
+	ctx.Next()
+
+	bytesSent := max(ctx.Writer.Size(), 0)
+	fields := []zap.Field{
+		zap.String("method", ctx.Request.Method),
+		zap.String("path", ctx.Request.URL.Path),
+		zap.Int("status", ctx.Writer.Status()),
+		zap.Int("bytesSent", bytesSent),
+		zap.Int64("bytesReceived", max(ctx.Request.ContentLength, 0)),
+		zap.Duration("duration", time.Since(start)),
+		zap.String("clientIP", ctx.ClientIP()),
+		zap.String("userAgent", ctx.Request.UserAgent()),
+		zap.Bool("aborted", errors.Is(ctx.Request.Context().Err(), context.Canceled)),
+	}
+	if entry.MessageID != 0 {
+		fields = append(fields, zap.Int("messageID", entry.MessageID))
+	}
+	if entry.FileName != "" {
+		fields = append(fields, zap.String("fileName", entry.FileName), zap.Int64("fileSize", entry.FileSize))
+	}
+	if entry.Range != "" {
+		fields = append(fields, zap.String("range", entry.Range))
+	}
+	if entry.RangeEnd >= 0 {
+		fields = append(fields, zap.Int64("rangeStart", entry.RangeStart), zap.Int64("rangeEnd", entry.RangeEnd))
+	}
+	if entry.Files != 0 {
+		fields = append(fields, zap.Int("files", entry.Files))
+	}
+	if entry.Worker != nil {
+		fields = append(fields, zap.Int("workerID", entry.Worker.ID), zap.String("worker", entry.Worker.Label))
+	}
+	utils.AccessLogger.Info("request", fields...)
+}
+
+// getAccessEntry returns the access log entry of the request. Requests not
+// logged get a throwaway one.
+func getAccessEntry(ctx *gin.Context) *accessEntry {
+	if entry, ok := ctx.Get(accessEntryKey); ok {
+		return entry.(*accessEntry)
+	}
+	return &accessEntry{}
+}
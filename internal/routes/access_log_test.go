@@ -0,0 +1,102 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"EverythingSuckz/fsb/internal/utils"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func setupAccessLogRouter(t *testing.T) (*gin.Engine, *observer.ObservedLogs) {
+	core, logs := observer.New(zap.InfoLevel)
+	prev := utils.AccessLogger
+	utils.AccessLogger = zap.New(core)
+	t.Cleanup(func() { utils.AccessLogger = prev })
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	if err := router.SetTrustedProxies([]string{"10.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	router.GET("/stream/:messageID", accessLog, func(ctx *gin.Context) {
+		entry := getAccessEntry(ctx)
+		entry.MessageID = 42
+		entry.FileName, entry.FileSize = "video.mp4", 1000
+		entry.Range = ctx.GetHeader("Range")
+		entry.RangeStart, entry.RangeEnd = 100, 199
+		ctx.Data(http.StatusPartialContent, "video/mp4", make([]byte, 100))
+	})
+	return router, logs
+}
+
+// TestAccessLog 测试访问日志记录的字段
+func TestAccessLog(t *testing.T) {
+	router, logs := setupAccessLogRouter(t)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		clientIP   string
+	}{
+		{"受信任代理", "10.0.0.1:1234", "203.0.113.7"},
+		{"不受信任的来源", "198.51.100.2:1234", "198.51.100.2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/stream/42?hash=secret", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set("X-Forwarded-For", "203.0.113.7")
+			req.Header.Set("Range", "bytes=100-199")
+			req.Header.Set("User-Agent", "test-agent")
+			router.ServeHTTP(httptest.NewRecorder(), req)
+
+			entries := logs.TakeAll()
+			if len(entries) != 1 {
+				t.Fatalf("期望 1 条访问日志, 得到 %d", len(entries))
+			}
+			fields := entries[0].ContextMap()
+			want := map[string]any{
+				"path":       "/stream/42",
+				"status":     int64(http.StatusPartialContent),
+				"messageID":  int64(42),
+				"fileName":   "video.mp4",
+				"fileSize":   int64(1000),
+				"range":      "bytes=100-199",
+				"rangeStart": int64(100),
+				"rangeEnd":   int64(199),
+				"bytesSent":  int64(100),
+				"clientIP":   tt.clientIP,
+				"userAgent":  "test-agent",
+				"aborted":    false,
+			}
+			for key, value := range want {
+				if fields[key] != value {
+					t.Errorf("%s: 期望 %v, 得到 %v", key, value, fields[key])
+				}
+			}
+		})
+	}
+}
+
+// TestAccessLog_Aborted 测试客户端断开连接时的记录
+func TestAccessLog_Aborted(t *testing.T) {
+	router, logs := setupAccessLogRouter(t)
+
+	reqCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest(http.MethodGet, "/stream/42", nil).WithContext(reqCtx)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	entries := logs.TakeAll()
+	if len(entries) != 1 {
+		t.Fatalf("期望 1 条访问日志, 得到 %d", len(entries))
+	}
+	if aborted := entries[0].ContextMap()["aborted"]; aborted != true {
+		t.Errorf("期望 aborted 为 true, 得到 %v", aborted)
+	}
+}
@@ -0,0 +1,68 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/utils"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+func (e *allRoutes) LoadAdmin(r *Route) {
+	log := e.log.Named("Admin")
+	if config.ValueOf.AdminToken == "" {
+		log.Info("ADMIN_TOKEN not set, skipping admin routes")
+		return
+	}
+	defer log.Info("Loaded admin routes")
+	admin := r.Engine.Group("/admin", requireAdmin)
+	admin.POST("/workers/reload", reloadWorkersRoute)
+	admin.GET("/log-level", getLogLevelRoute)
+	admin.PUT("/log-level", setLogLevelRoute)
+}
+
+// requireAdmin rejects requests without the admin bearer token.
+func requireAdmin(ctx *gin.Context) {
+	token, ok := strings.CutPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(config.ValueOf.AdminToken)) != 1 {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"ok": false, "error": "unauthorized"})
+		return
+	}
+	ctx.Next()
+}
+
+func reloadWorkersRoute(ctx *gin.Context) {
+	result, err := bot.ReloadWorkers()
+	if err != nil {
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"ok": false, "error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"ok": true, "workers": result})
+}
+
+func getLogLevelRoute(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"ok": true, "level": utils.GetLogLevels().String()})
+}
+
+// setLogLevelRoute changes the log levels until the next restart, e.g.
+// {"level": "info,Stream=debug"}.
+func setLogLevelRoute(ctx *gin.Context) {
+	var body struct {
+		Level string `json:"level" binding:"required"`
+	}
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"ok": false, "error": err.Error()})
+		return
+	}
+	levels, err := utils.SetLogLevels(body.Level)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"ok": false, "error": err.Error()})
+		return
+	}
+	utils.Logger.Named("Admin").Info("Changed log level", zap.String("level", levels.String()))
+	ctx.JSON(http.StatusOK, gin.H{"ok": true, "level": levels.String()})
+}
@@ -0,0 +1,107 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/cache"
+	"EverythingSuckz/fsb/internal/utils"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readyCheckTimeout bounds the Telegram calls made by /readyz.
+const readyCheckTimeout = 5 * time.Second
+
+// readyCheck is the result of one readiness check.
+type readyCheck struct {
+	Ok      bool   `json:"ok"`
+	Error   string `json:"error,omitempty"`
+	Healthy *int   `json:"healthy,omitempty"`
+	Total   *int   `json:"total,omitempty"`
+}
+
+func (e *allRoutes) LoadHealth(r *Route) {
+	log := e.log.Named("Health")
+	defer log.Info("Loaded health routes")
+	r.Engine.GET("/healthz", getHealthzRoute)
+	r.Engine.GET("/readyz", getReadyzRoute)
+}
+
+// getHealthzRoute answers as long as the process serves HTTP.
+func getHealthzRoute(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// getReadyzRoute reports whether streams and uploads can be served, with
+// 503 and the failed checks otherwise.
+func getReadyzRoute(ctx *gin.Context) {
+	checkCtx, cancel := context.WithTimeout(ctx.Request.Context(), readyCheckTimeout)
+	defer cancel()
+	checks := map[string]readyCheck{
+		"telegram":   checkResult(checkMainBot(checkCtx)),
+		"workers":    checkWorkers(),
+		"logChannel": checkResult(checkLogChannel(checkCtx)),
+		"cache":      checkResult(checkCache()),
+	}
+	ready := true
+	for _, check := range checks {
+		ready = ready && check.Ok
+	}
+	status := http.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+	ctx.JSON(status, gin.H{"ok": ready, "checks": checks})
+}
+
+func checkResult(err error) readyCheck {
+	if err != nil {
+		return readyCheck{Error: err.Error()}
+	}
+	return readyCheck{Ok: true}
+}
+
+func checkMainBot(ctx context.Context) error {
+	client := bot.Bot()
+	if client == nil {
+		return errors.New("main bot is not started")
+	}
+	return client.Ping(ctx)
+}
+
+func checkWorkers() readyCheck {
+	workers := bot.GetWorkersHealth()
+	healthy := 0
+	for _, worker := range workers {
+		if worker.Healthy {
+			healthy++
+		}
+	}
+	total := len(workers)
+	check := readyCheck{Ok: healthy >= config.ValueOf.ReadyWorkers, Healthy: &healthy, Total: &total}
+	if !check.Ok {
+		check.Error = fmt.Sprintf("%d healthy workers, %d required", healthy, config.ValueOf.ReadyWorkers)
+	}
+	return check
+}
+
+func checkLogChannel(ctx context.Context) error {
+	client := bot.Bot()
+	if client == nil {
+		return errors.New("main bot is not started")
+	}
+	_, err := utils.GetLogChannelPeer(ctx, client.API(), client.PeerStorage)
+	return err
+}
+
+func checkCache() error {
+	if cache.GetCache() == nil {
+		return errors.New("cache is not initialized")
+	}
+	return nil
+}
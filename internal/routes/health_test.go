@@ -0,0 +1,73 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/cache"
+	"EverythingSuckz/fsb/internal/utils"
+	"github.com/gin-gonic/gin"
+)
+
+func setupHealthRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	utils.InitLogger(true)
+	route := &Route{Name: "/", Engine: router}
+	(&allRoutes{log: utils.Logger}).LoadHealth(route)
+	return router
+}
+
+// TestHealthz 测试存活检查始终返回200
+func TestHealthz(t *testing.T) {
+	router := setupHealthRouter()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("期望状态码 200, 得到 %d", w.Code)
+	}
+}
+
+// TestReadyz_NotReady 测试Telegram未连接时就绪检查返回503和失败原因
+func TestReadyz_NotReady(t *testing.T) {
+	config.ValueOf.ReadyWorkers = 1
+	cache.InitCache(utils.Logger)
+	router := setupHealthRouter()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("期望状态码 503, 得到 %d", w.Code)
+	}
+	var body struct {
+		Ok     bool                  `json:"ok"`
+		Checks map[string]readyCheck `json:"checks"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("解析响应失败: %v", err)
+	}
+	if body.Ok {
+		t.Error("期望 ok 为 false")
+	}
+	for name, ok := range map[string]bool{
+		"telegram":   false,
+		"workers":    false,
+		"logChannel": false,
+		"cache":      true,
+	} {
+		check, exists := body.Checks[name]
+		if !exists {
+			t.Errorf("缺少检查项 %s", name)
+			continue
+		}
+		if check.Ok != ok {
+			t.Errorf("%s: 期望 ok=%v, 得到 %v (%s)", name, ok, check.Ok, check.Error)
+		}
+		if !check.Ok && check.Error == "" {
+			t.Errorf("%s: 失败时应返回原因", name)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/internal/links"
+	"EverythingSuckz/fsb/internal/types"
+	"EverythingSuckz/fsb/internal/utils"
+	"errors"
+	"net/http"
+)
+
+// authorizeFile checks the hash of a request against the original link of
+// the file and, if the user renamed it or set an expiry, its custom link.
+// Once a link has an expiry, its original link stops working.
+// It returns the file to serve along with an HTTP status for errors.
+func authorizeFile(messageID int, file *types.File, authHash string) (*types.File, int, error) {
+	link, err := links.Get(messageID)
+	if err != nil && !errors.Is(err, links.ErrNotFound) {
+		return nil, http.StatusInternalServerError, err
+	}
+	if err != nil || link.FileID != file.ID {
+		link = nil
+	}
+	if matchesOriginalLink(file, authHash) {
+		if link == nil || link.ExpiresAt == 0 {
+			return file, http.StatusOK, nil
+		}
+		if link.Expired() {
+			return nil, http.StatusGone, errors.New("link expired")
+		}
+		return nil, http.StatusForbidden, errors.New("link was replaced by a link with an expiry")
+	}
+	if link != nil && link.HasOptions() {
+		customHash := utils.PackLink(link.ServedName(), file.FileSize, file.MimeType, file.ID, link.ExpiresAt)
+		if utils.CheckHash(authHash, customHash) {
+			if link.Expired() {
+				return nil, http.StatusGone, errors.New("link expired")
+			}
+			served := *file
+			served.FileName = link.ServedName()
+			served.ExpiresAt = link.ExpiresAt
+			return &served, http.StatusOK, nil
+		}
+	}
+	return nil, http.StatusBadRequest, errors.New("invalid hash")
+}
+
+// matchesOriginalLink reports whether hash is the one of the link the bot
+// first sent for file, by this or an older version.
+func matchesOriginalLink(file *types.File, hash string) bool {
+	expectedHash := utils.PackFile(
+		file.FileName,
+		file.FileSize,
+		file.MimeType,
+		file.ID,
+	)
+	if utils.CheckHash(hash, expectedHash) {
+		return true
+	}
+	for _, legacyHash := range utils.LegacyHashes(file) {
+		if utils.CheckHash(hash, legacyHash) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package routes
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/links"
+	"EverythingSuckz/fsb/internal/types"
+	"EverythingSuckz/fsb/internal/utils"
+
+	"github.com/gotd/td/tg"
+	"go.uber.org/zap"
+)
+
+func TestAuthorizeFile(t *testing.T) {
+	config.ValueOf.HashLength = 6
+	if err := links.Init(zap.NewNop(), filepath.Join(t.TempDir(), "links.db")); err != nil {
+		t.Fatal(err)
+	}
+	file := &types.File{
+		Location: &tg.InputDocumentFileLocation{ID: 100},
+		FileName: "movie.mkv",
+		FileSize: 1000,
+		MimeType: "video/x-matroska",
+		ID:       100,
+	}
+	hash := func(name string, expiresAt int64) string {
+		return utils.GetShortHash(utils.PackLink(name, file.FileSize, file.MimeType, file.ID, expiresAt))
+	}
+	save := func(messageID int, name string, expiresAt int64) {
+		err := links.Save(&links.Link{
+			MessageID:    messageID,
+			FileID:       file.ID,
+			FileSize:     file.FileSize,
+			MimeType:     file.MimeType,
+			OriginalName: file.FileName,
+			FileName:     name,
+			ExpiresAt:    expiresAt,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	future := time.Now().Add(time.Hour).Unix()
+	past := time.Now().Add(-time.Hour).Unix()
+	save(2, "renamed.mkv", 0)
+	save(3, "", future)
+	save(4, "renamed.mkv", past)
+	save(5, "", past)
+
+	tests := []struct {
+		name      string
+		messageID int
+		hash      string
+		status    int
+		served    string
+	}{
+		{"original without options", 1, hash("movie.mkv", 0), http.StatusOK, "movie.mkv"},
+		{"wrong hash", 1, "abcdef", http.StatusBadRequest, ""},
+		{"original of renamed", 2, hash("movie.mkv", 0), http.StatusOK, "movie.mkv"},
+		{"renamed", 2, hash("renamed.mkv", 0), http.StatusOK, "renamed.mkv"},
+		{"expiring", 3, hash("movie.mkv", future), http.StatusOK, "movie.mkv"},
+		{"original of expiring", 3, hash("movie.mkv", 0), http.StatusForbidden, ""},
+		{"renamed and expired", 4, hash("renamed.mkv", past), http.StatusGone, ""},
+		{"original of expired", 5, hash("movie.mkv", 0), http.StatusGone, ""},
+		{"expiry of another message", 1, hash("movie.mkv", future), http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			served, status, err := authorizeFile(tt.messageID, file, tt.hash)
+			if status != tt.status {
+				t.Fatalf("got status %d (%v), want %d", status, err, tt.status)
+			}
+			if tt.status == http.StatusOK && served.FileName != tt.served {
+				t.Errorf("served as %q, want %q", served.FileName, tt.served)
+			}
+			if tt.messageID == 3 && tt.status == http.StatusOK && served.ExpiresAt != future {
+				t.Errorf("served with expiry %d, want %d", served.ExpiresAt, future)
+			}
+		})
+	}
+}
+
+// Links sent before photos were hashed with their size keep working, as
+// long as they weren't given an expiry.
+func TestAuthorizeFileLegacyHash(t *testing.T) {
+	config.ValueOf.HashLength = 6
+	if err := links.Init(zap.NewNop(), filepath.Join(t.TempDir(), "links.db")); err != nil {
+		t.Fatal(err)
+	}
+	photo := &types.File{
+		Location: &tg.InputPhotoFileLocation{ID: 200, ThumbSize: "y"},
+		FileName: "photo_200.jpg",
+		FileSize: 50000,
+		MimeType: "image/jpeg",
+		ID:       200,
+	}
+	legacy := utils.GetShortHash(utils.PackFile(photo.FileName, 0, photo.MimeType, photo.ID))
+	if _, status, err := authorizeFile(1, photo, legacy); status != http.StatusOK {
+		t.Errorf("legacy photo hash rejected with %d: %v", status, err)
+	}
+	err := links.Save(&links.Link{MessageID: 2, FileID: photo.ID, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, status, _ := authorizeFile(2, photo, legacy); status != http.StatusForbidden {
+		t.Errorf("legacy hash of an expiring link got %d", status)
+	}
+}
@@ -0,0 +1,32 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/metrics"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (e *allRoutes) LoadMetrics(r *Route) {
+	log := e.log.Named("Metrics")
+	defer log.Info("Loaded metrics route")
+	r.Engine.GET("/metrics", requireMetricsToken, gin.WrapH(metrics.Handler()))
+}
+
+// requireMetricsToken rejects requests without the METRICS_TOKEN bearer
+// token, if one is set.
+func requireMetricsToken(ctx *gin.Context) {
+	if config.ValueOf.MetricsToken == "" {
+		ctx.Next()
+		return
+	}
+	token, ok := strings.CutPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(config.ValueOf.MetricsToken)) != 1 {
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	ctx.Next()
+}
@@ -0,0 +1,80 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/metrics"
+	"EverythingSuckz/fsb/internal/utils"
+	"github.com/gin-gonic/gin"
+)
+
+func setupMetricsRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	utils.InitLogger(true)
+	route := &Route{Name: "/", Engine: router}
+	(&allRoutes{log: utils.Logger}).LoadMetrics(route)
+	return router
+}
+
+// TestMetricsRoute 测试Prometheus指标输出
+func TestMetricsRoute(t *testing.T) {
+	config.ValueOf.MetricsToken = ""
+	router := setupMetricsRouter()
+	metrics.Uploads.WithLabelValues("success").Inc()
+	metrics.ObserveStream(http.StatusPartialContent, 1024, time.Now())
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("期望状态码 200, 得到 %d", w.Code)
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		`fsb_stream_requests_total{status="206"}`,
+		"fsb_stream_bytes_total",
+		"fsb_stream_duration_seconds_bucket",
+		`fsb_upload_requests_total{result="success"}`,
+		"fsb_cache_hits_total",
+		"go_goroutines",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("指标输出中缺少 %s", want)
+		}
+	}
+}
+
+// TestMetricsRoute_Token 测试设置METRICS_TOKEN后需要认证
+func TestMetricsRoute_Token(t *testing.T) {
+	config.ValueOf.MetricsToken = "metrics-secret"
+	defer func() { config.ValueOf.MetricsToken = "" }()
+	router := setupMetricsRouter()
+
+	tests := []struct {
+		name   string
+		header string
+		code   int
+	}{
+		{"无令牌", "", http.StatusUnauthorized},
+		{"错误令牌", "Bearer wrong", http.StatusUnauthorized},
+		{"正确令牌", "Bearer metrics-secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.code {
+				t.Errorf("期望状态码 %d, 得到 %d", tt.code, w.Code)
+			}
+		})
+	}
+}
@@ -2,13 +2,14 @@ package routes
 
 import (
 	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/metrics"
 	"EverythingSuckz/fsb/internal/utils"
 	"fmt"
 	"io"
 	"net/http"
 	"strconv"
+	"time"
 
-	"github.com/gotd/td/tg"
 	range_parser "github.com/quantumsheep/range-parser"
 	"go.uber.org/zap"
 
@@ -20,12 +21,21 @@ var log *zap.Logger
 func (e *allRoutes) LoadHome(r *Route) {
 	log = e.log.Named("Stream")
 	defer log.Info("Loaded stream route")
-	r.Engine.GET("/stream/:messageID", getStreamRoute)
+	r.Engine.GET("/stream/:messageID", accessLog, getStreamRoute)
 }
 
 func getStreamRoute(ctx *gin.Context) {
 	w := ctx.Writer
 	r := ctx.Request
+	entry := getAccessEntry(ctx)
+	entry.Range = r.Header.Get("Range")
+
+	requestStart := time.Now()
+	metrics.ActiveStreams.Inc()
+	defer func() {
+		metrics.ActiveStreams.Dec()
+		metrics.ObserveStream(w.Status(), int64(w.Size()), requestStart)
+	}()
 
 	messageIDParm := ctx.Param("messageID")
 	messageID, err := strconv.Atoi(messageIDParm)
@@ -33,6 +43,7 @@ func getStreamRoute(ctx *gin.Context) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	entry.MessageID = messageID
 
 	authHash := ctx.Query("hash")
 	if authHash == "" {
@@ -40,49 +51,33 @@ func getStreamRoute(ctx *gin.Context) {
 		return
 	}
 
-	worker := bot.GetNextWorker()
+	worker := bot.StartStream(messageID)
+	entry.Worker = worker
+	defer worker.StreamFinished()
+	client := worker.Client()
 
-	file, err := utils.FileFromMessage(ctx, worker.Client, messageID)
+	file, err := utils.FileFromMessage(ctx.Request.Context(), client, messageID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	expectedHash := utils.PackFile(
-		file.FileName,
-		file.FileSize,
-		file.MimeType,
-		file.ID,
-	)
-	if !utils.CheckHash(authHash, expectedHash) {
-		http.Error(w, "invalid hash", http.StatusBadRequest)
+	file, status, err := authorizeFile(messageID, file, authHash)
+	if err != nil {
+		http.Error(w, err.Error(), status)
 		return
 	}
 
-	// for photo messages
-	if file.FileSize == 0 {
-		res, err := worker.Client.API().UploadGetFile(ctx, &tg.UploadGetFileRequest{
-			Location: file.Location,
-			Offset:   0,
-			Limit:    1024 * 1024,
-		})
+	if sizeType := ctx.Query("size"); sizeType != "" {
+		file, err = utils.PhotoSizeFile(file, sizeType)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		result, ok := res.(*tg.UploadFile)
-		if !ok {
-			http.Error(w, "unexpected response", http.StatusInternalServerError)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		fileBytes := result.GetBytes()
-		ctx.Header("Content-Disposition", fmt.Sprintf("inline; filename=\"%s\"", file.FileName))
-		if r.Method != "HEAD" {
-			ctx.Data(http.StatusOK, file.MimeType, fileBytes)
-		}
-		return
 	}
 
+	entry.FileName, entry.FileSize = file.FileName, file.FileSize
+
 	ctx.Header("Accept-Ranges", "bytes")
 	var start, end int64
 	rangeHeader := r.Header.Get("Range")
@@ -100,9 +95,10 @@ func getStreamRoute(ctx *gin.Context) {
 		start = ranges[0].Start
 		end = ranges[0].End
 		ctx.Header("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, file.FileSize))
-		log.Info("Content-Range", zap.Int64("start", start), zap.Int64("end", end), zap.Int64("fileSize", file.FileSize))
+		log.Debug("Content-Range", zap.Int64("start", start), zap.Int64("end", end), zap.Int64("fileSize", file.FileSize))
 		w.WriteHeader(http.StatusPartialContent)
 	}
+	entry.RangeStart, entry.RangeEnd = start, end
 
 	contentLength := end - start + 1
 	mimeType := file.MimeType
@@ -123,7 +119,7 @@ func getStreamRoute(ctx *gin.Context) {
 	ctx.Header("Content-Disposition", fmt.Sprintf("%s; filename=\"%s\"", disposition, file.FileName))
 
 	if r.Method != "HEAD" {
-		lr, _ := utils.NewTelegramReader(ctx, worker.Client, file.Location, start, end, contentLength)
+		lr, _ := utils.NewTelegramReader(ctx.Request.Context(), client, file.Location, start, end, contentLength)
 		if _, err := io.CopyN(w, lr, contentLength); err != nil {
 			log.Error("Error while copying stream", zap.Error(err))
 		}
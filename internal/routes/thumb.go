@@ -0,0 +1,103 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/utils"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// thumbnails never change for a given file, so let clients keep them for a week
+const thumbCacheControl = "public, max-age=604800, immutable"
+
+// thumbCacheHeader returns the Cache-Control of a thumbnail. Thumbnails of
+// links with an expiry are only cached by the client, until the link expires.
+func thumbCacheHeader(expiresAt int64, now time.Time) string {
+	if expiresAt == 0 {
+		return thumbCacheControl
+	}
+	maxAge := min(expiresAt-now.Unix(), 604800)
+	return fmt.Sprintf("private, max-age=%d", max(maxAge, 0))
+}
+
+var thumbLog *zap.Logger
+
+func (e *allRoutes) LoadThumb(r *Route) {
+	thumbLog = e.log.Named("Thumb")
+	defer thumbLog.Info("Loaded thumb route")
+	r.Engine.GET("/thumb/:messageID", getThumbRoute)
+}
+
+func getThumbRoute(ctx *gin.Context) {
+	w := ctx.Writer
+	r := ctx.Request
+
+	messageID, err := strconv.Atoi(ctx.Param("messageID"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	authHash := ctx.Query("hash")
+	if authHash == "" {
+		http.Error(w, "missing hash param", http.StatusBadRequest)
+		return
+	}
+
+	worker := bot.StartStream(messageID)
+	defer worker.StreamFinished()
+	client := worker.Client()
+
+	file, err := utils.FileFromMessage(ctx.Request.Context(), client, messageID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	file, status, err := authorizeFile(messageID, file, authHash)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	sizeType := ctx.Query("size")
+	etag := fmt.Sprintf(`"%d-%s"`, file.ID, sizeType)
+	if r.Header.Get("If-None-Match") == etag {
+		ctx.Status(http.StatusNotModified)
+		return
+	}
+
+	var data []byte
+	thumb, err := utils.ThumbFile(file, sizeType)
+	switch {
+	case err == nil:
+		lr, _ := utils.NewTelegramReader(ctx.Request.Context(), client, thumb.Location, 0, thumb.FileSize-1, thumb.FileSize)
+		data, err = io.ReadAll(lr)
+		if err != nil {
+			thumbLog.Error("Error while fetching thumbnail", zap.Int("messageID", messageID), zap.Error(err))
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	case errors.Is(err, utils.ErrNoThumbnail) && sizeType == "":
+		data, err = utils.StrippedThumbJPEG(file)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+	default:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	ctx.Header("Cache-Control", thumbCacheHeader(file.ExpiresAt, time.Now()))
+	ctx.Header("ETag", etag)
+	ctx.Header("Content-Disposition", fmt.Sprintf("inline; filename=\"thumb_%d.jpg\"", file.ID))
+	ctx.Data(http.StatusOK, http.DetectContentType(data), data)
+}
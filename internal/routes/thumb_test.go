@@ -0,0 +1,28 @@
+package routes
+
+import (
+	"testing"
+	"time"
+)
+
+// TestThumbCacheHeader 测试有效期链接的缩略图只在客户端缓存到链接过期
+func TestThumbCacheHeader(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		name      string
+		expiresAt int64
+		want      string
+	}{
+		{"无有效期", 0, thumbCacheControl},
+		{"一小时后过期", now.Unix() + 3600, "private, max-age=3600"},
+		{"一个月后过期", now.Unix() + 30*86400, "private, max-age=604800"},
+		{"已过期", now.Unix() - 10, "private, max-age=0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := thumbCacheHeader(tt.expiresAt, now); got != tt.want {
+				t.Errorf("期望 %q, 得到 %q", tt.want, got)
+			}
+		})
+	}
+}
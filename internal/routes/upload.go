@@ -1,7 +1,9 @@
 package routes
 
 import (
+	"context"
 	"crypto/md5"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
@@ -12,12 +14,15 @@ import (
 
 	"EverythingSuckz/fsb/config"
 	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/metrics"
+	"EverythingSuckz/fsb/internal/tracing"
 	"EverythingSuckz/fsb/internal/types"
 	"EverythingSuckz/fsb/internal/utils"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gotd/td/telegram/uploader"
 	"github.com/gotd/td/tg"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 )
 
@@ -37,7 +42,15 @@ type UploadMetrics struct {
 	BlockedUploads   int64 `json:"blockedUploads"`
 	ActiveUsers     int    `json:"activeUsers"`
 	AverageSize     float64 `json:"averageSize"`
+	UploadedBytes     int64            `json:"uploadedBytes"`     // 已发送到Telegram的字节数，包括失败的上传
+	AverageThroughput float64          `json:"averageThroughput"` // 成功上传的平均速度（字节/秒）
+	CurrentThroughput float64          `json:"currentThroughput"` // 进行中上传的总速度（字节/秒）
+	ActiveUploads     []UploadProgress `json:"activeUploads"`
 	mutex           sync.Mutex
+	active          map[int64]*uploadTracker
+	nextUploadID    int64
+	transferBytes   int64
+	transferTime    time.Duration
 }
 
 // 初始化上传组件
@@ -64,6 +77,7 @@ func initUploadComponents(log *zap.Logger) {
 
 	// 初始化上传指标
 	uploadMetrics = &UploadMetrics{}
+	metrics.QuotaLimitBytes.Set(float64(max(config.ValueOf.UserQuota, 0)))
 }
 
 // 注册上传路由
@@ -81,8 +95,8 @@ func (e *allRoutes) LoadUpload(r *Route) {
 	initUploadComponents(log)
 
 	// 注册路由
-	r.Engine.POST("/upload", handleUpload)
-	r.Engine.POST("/upload/batch", handleBatchUpload)
+	r.Engine.POST("/upload", accessLog, handleUpload)
+	r.Engine.POST("/upload/batch", accessLog, handleBatchUpload)
 	r.Engine.GET("/upload/status", handleUploadStatus)
 	r.Engine.GET("/upload/metrics", handleUploadMetrics)
 
@@ -116,6 +130,7 @@ func handleUpload(ctx *gin.Context) {
 	// 3. 速率检查
 	canUpload, waitTime := rateLimiter.CheckLimit(userID)
 	if !canUpload {
+		metrics.Uploads.WithLabelValues("rate_limited").Inc()
 		ctx.JSON(http.StatusTooManyRequests, gin.H{
 			"error": fmt.Sprintf("请等待 %v 后再试", waitTime),
 			"code":  429,
@@ -135,6 +150,8 @@ func handleUpload(ctx *gin.Context) {
 		return
 	}
 	defer file.Close()
+	entry := getAccessEntry(ctx)
+	entry.FileName, entry.FileSize = header.Filename, header.Size
 
 	// 5. 文件验证
 	if err := validateUploadedFile(header); err != nil {
@@ -165,9 +182,14 @@ func handleUpload(ctx *gin.Context) {
 			zap.String("filename", header.Filename),
 			zap.String("userID", userID))
 
-		ctx.JSON(http.StatusInternalServerError, gin.H{
+		// 排队超时说明所有worker都不可用
+		status := http.StatusInternalServerError
+		if errors.Is(err, bot.ErrNoUploadWorker) {
+			status = http.StatusServiceUnavailable
+		}
+		ctx.JSON(status, gin.H{
 			"error": "上传失败: " + err.Error(),
-			"code":  500,
+			"code":  status,
 		})
 		updateMetrics(false, 0, userID)
 		return
@@ -227,6 +249,7 @@ func handleBatchUpload(ctx *gin.Context) {
 	}
 
 	files := form.File["files"]
+	getAccessEntry(ctx).Files = len(files)
 	if len(files) == 0 {
 		ctx.JSON(http.StatusBadRequest, gin.H{
 			"error": "未找到文件",
@@ -374,6 +397,7 @@ func handleUploadStatus(ctx *gin.Context) {
 func handleUploadMetrics(ctx *gin.Context) {
 	uploadMetrics.mutex.Lock()
 	defer uploadMetrics.mutex.Unlock()
+	uploadMetrics.refreshProgress(time.Now())
 
 	ctx.JSON(http.StatusOK, gin.H{
 		"metrics": uploadMetrics,
@@ -459,18 +483,96 @@ func validateUploadedFile(header *multipart.FileHeader) error {
 	)
 }
 
-// 上传文件到Telegram
+// 超过此大小的文件按大文件分片上传（Telegram的限制）
+const bigFileSize = 10 * 1024 * 1024
+
+// 上传文件到Telegram，失败（包括FLOOD_WAIT）时自动换一个worker重试
 func uploadToTelegram(ctx *gin.Context, file multipart.File, header *multipart.FileHeader) (*types.UploadResult, error) {
-	// 获取可用的上传worker
-	worker := bot.GetNextUploadWorker()
-	if worker == nil {
-		return nil, fmt.Errorf("没有可用的worker")
+	log := utils.Logger.Named("Upload")
+	reqCtx := ctx.Request.Context()
+	attempts := max(config.ValueOf.UploadRetries, 0) + 1
+	tried := make(map[int]bool)
+	var lastErr error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		// 获取可用的上传worker，全部不可用时排队等待
+		acquireCtx, span := tracing.Start(reqCtx, "upload.acquire_worker", attribute.Int("upload.attempt", attempt))
+		worker, err := bot.AcquireUploadWorker(acquireCtx, tried)
+		tracing.End(span, err)
+		if err != nil {
+			if lastErr != nil {
+				return nil, fmt.Errorf("%w (上次错误: %v)", err, lastErr)
+			}
+			return nil, err
+		}
+		// 重试时从头读取文件
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			worker.UploadFinished()
+			return nil, fmt.Errorf("重置文件读取位置失败: %w", err)
+		}
+		// FLOOD_WAIT直接返回，不在当前worker上等待
+		attemptCtx, span := tracing.Start(reqCtx, "upload.attempt",
+			attribute.Int("upload.attempt", attempt),
+			attribute.String("fsb.worker", worker.Label),
+		)
+		result, err := uploadWithWorker(bot.WithoutFloodWait(attemptCtx), worker, file, header)
+		tracing.End(span, err)
+		getAccessEntry(ctx).Worker = worker
+		if err == nil {
+			return result, nil
+		}
+		if reqCtx.Err() != nil {
+			return nil, err
+		}
+		tried[worker.ID] = true
+		lastErr = err
+		if wait, ok := bot.AsFloodWait(err); ok {
+			log.Warn("worker触发FLOOD_WAIT，换worker重试",
+				zap.String("worker", worker.Label),
+				zap.Duration("wait", wait),
+				zap.Int("attempt", attempt))
+		} else if attempt < attempts {
+			log.Warn("上传失败，换worker重试",
+				zap.String("worker", worker.Label),
+				zap.Int("attempt", attempt),
+				zap.Error(err))
+		}
 	}
+	return nil, lastErr
+}
 
-	// 上传文件到Telegram - 使用FromReader方法
+// 使用指定worker上传文件
+func uploadWithWorker(ctx context.Context, worker *bot.Worker, file multipart.File, header *multipart.FileHeader) (*types.UploadResult, error) {
+	// AcquireUploadWorker已标记上传进行中，避免worker在重载时被提前停止
+	defer worker.UploadFinished()
+
+	// 上传文件到Telegram，大文件的分片并行上传
 	sanitizedFilename := utils.SanitizeFilename(header.Filename)
-	u := uploader.NewUploader(worker.Client.API())
-	upload, err := u.FromReader(ctx, sanitizedFilename, file)
+	client := worker.Client()
+	api := client.API()
+	connections := 1
+	if config.ValueOf.UploadConnections > 1 && header.Size > bigFileSize {
+		// 大文件的分片分散到同一bot会话的多个连接上
+		pool, closePool, err := worker.UploadConnections(config.ValueOf.UploadConnections)
+		if err != nil {
+			return nil, err
+		}
+		defer closePool()
+		api = pool
+		connections = config.ValueOf.UploadConnections
+	}
+	tracker := uploadMetrics.startUpload(sanitizedFilename, worker.Label, connections, header.Size)
+	u := uploader.NewUploader(api).
+		WithThreads(config.ValueOf.UploadThreads).
+		WithPartSize(config.ValueOf.UploadPartSize * 1024).
+		WithProgress(tracker)
+	partsCtx, span := tracing.Start(ctx, "upload.parts",
+		attribute.Int64("file.size", header.Size),
+		attribute.Int("upload.connections", connections),
+		attribute.Int("upload.threads", config.ValueOf.UploadThreads),
+	)
+	upload, err := u.Upload(partsCtx, uploader.NewUpload(sanitizedFilename, file, header.Size))
+	tracing.End(span, err)
+	tracker.finish(err == nil)
 	if err != nil {
 		return nil, fmt.Errorf("文件上传失败: %w", err)
 	}
@@ -510,7 +612,7 @@ func uploadToTelegram(ctx *gin.Context, file multipart.File, header *multipart.F
 	}
 
 	// 获取LOG_CHANNEL的InputPeer
-	logChannelPeer, err := utils.GetLogChannelPeer(ctx, worker.Client.API(), worker.Client.PeerStorage)
+	logChannelPeer, err := utils.GetLogChannelPeer(ctx, api, client.PeerStorage)
 	if err != nil {
 		return nil, fmt.Errorf("获取日志频道失败: %w", err)
 	}
@@ -523,55 +625,31 @@ func uploadToTelegram(ctx *gin.Context, file multipart.File, header *multipart.F
 		RandomID: time.Now().UnixNano(), // 必需的RandomID字段
 	}
 
-	update, err := worker.Client.API().MessagesSendMedia(ctx, req)
+	sendCtx, span := tracing.Start(ctx, "upload.send_media", attribute.String("media.type", mediaType))
+	update, err := api.MessagesSendMedia(sendCtx, req)
+	tracing.End(span, err)
 	if err != nil {
 		return nil, fmt.Errorf("发送消息失败: %w", err)
 	}
 
-	// 解析结果获取消息ID和文件ID
-	var messageID int
-	var fileID int64
-	switch result := update.(type) {
-	case *tg.Updates:
-		if len(result.Updates) > 0 {
-			for _, upd := range result.Updates {
-				if updateMsg, ok := upd.(*tg.UpdateMessageID); ok {
-					messageID = updateMsg.ID
-				}
-				if updateNewMsg, ok := upd.(*tg.UpdateNewChannelMessage); ok {
-					if msg, ok := updateNewMsg.Message.(*tg.Message); ok {
-						messageID = msg.ID
-						// 从消息中提取文件ID
-						if msgMedia, ok := msg.Media.(*tg.MessageMediaDocument); ok {
-							if doc, ok := msgMedia.Document.(*tg.Document); ok {
-								fileID = doc.ID
-							}
-						} else if msgMedia, ok := msg.Media.(*tg.MessageMediaPhoto); ok {
-							if photo, ok := msgMedia.Photo.(*tg.Photo); ok {
-								fileID = photo.ID
-							}
-						}
-					}
-				}
-			}
-		}
-	}
-
-	if messageID == 0 {
-		return nil, fmt.Errorf("无法获取消息ID")
-	}
+	return uploadResult(update, sanitizedFilename, header)
+}
 
-	// 如果没有获取到文件ID，使用消息ID作为备选
-	if fileID == 0 {
-		fileID = int64(messageID)
+// uploadResult 根据发送结果生成上传结果和流媒体链接
+func uploadResult(update tg.UpdatesClass, sanitizedFilename string, header *multipart.FileHeader) (*types.UploadResult, error) {
+	// 解析结果获取消息ID和Telegram保存的文件
+	messageID, sent, err := sentFile(update)
+	if err != nil {
+		return nil, fmt.Errorf("解析发送结果失败: %w", err)
 	}
 
-	// 生成流媒体链接
+	// 生成流媒体链接，哈希与/stream校验时一样基于Telegram保存的文件计算
+	// （照片会被Telegram重新编码，文件名和大小与上传的文件不同）
 	fullHash := utils.PackFile(
-		sanitizedFilename,
-		header.Size,
-		header.Header.Get("Content-Type"),
-		fileID,
+		sent.FileName,
+		sent.FileSize,
+		sent.MimeType,
+		sent.ID,
 	)
 	hash := utils.GetShortHash(fullHash)
 
@@ -588,6 +666,26 @@ func uploadToTelegram(ctx *gin.Context, file multipart.File, header *multipart.F
 	}, nil
 }
 
+// sentFile 从MessagesSendMedia的结果中取出消息ID和消息中的文件
+func sentFile(update tg.UpdatesClass) (int, *types.File, error) {
+	result, ok := update.(*tg.Updates)
+	if !ok {
+		return 0, nil, fmt.Errorf("意外的结果类型 %T", update)
+	}
+	for _, upd := range result.Updates {
+		if updateNewMsg, ok := upd.(*tg.UpdateNewChannelMessage); ok {
+			if msg, ok := updateNewMsg.Message.(*tg.Message); ok {
+				file, err := utils.FileFromMedia(msg.Media)
+				if err != nil {
+					return 0, nil, fmt.Errorf("无法获取文件信息: %w", err)
+				}
+				return msg.ID, file, nil
+			}
+		}
+	}
+	return 0, nil, fmt.Errorf("无法获取消息ID")
+}
+
 // 确定媒体类型
 func determineMediaType(contentType string) string {
 	if strings.HasPrefix(contentType, "image/") {
@@ -610,8 +708,14 @@ func updateMetrics(success bool, fileSize int64, userID string) {
 
 	if success {
 		uploadMetrics.TotalSize += fileSize
+		metrics.Uploads.WithLabelValues("success").Inc()
+		metrics.UploadBytes.Add(float64(fileSize))
+		if config.ValueOf.UserQuota > 0 {
+			metrics.QuotaUsedBytes.Add(float64(fileSize))
+		}
 	} else {
 		uploadMetrics.FailedUploads++
+		metrics.Uploads.WithLabelValues("failed").Inc()
 	}
 
 	// 计算平均大小
@@ -0,0 +1,103 @@
+package routes
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/gotd/td/telegram/uploader"
+)
+
+// 进行中上传的进度
+type UploadProgress struct {
+	ID          int64     `json:"id"`
+	Filename    string    `json:"filename"`
+	Worker      string    `json:"worker"`
+	Connections int       `json:"connections"`
+	Size        int64     `json:"size"`
+	Uploaded    int64     `json:"uploaded"`
+	Percent     float64   `json:"percent"`
+	Throughput  float64   `json:"throughput"` // 字节/秒
+	StartedAt   time.Time `json:"startedAt"`
+}
+
+// 跟踪单次上传的分片进度，实现uploader.Progress
+type uploadTracker struct {
+	metrics  *UploadMetrics
+	progress UploadProgress // 由metrics.mutex保护
+}
+
+var _ uploader.Progress = (*uploadTracker)(nil)
+
+// 开始跟踪一次上传
+func (m *UploadMetrics) startUpload(filename, worker string, connections int, size int64) *uploadTracker {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.active == nil {
+		m.active = make(map[int64]*uploadTracker)
+	}
+	m.nextUploadID++
+	t := &uploadTracker{
+		metrics: m,
+		progress: UploadProgress{
+			ID:          m.nextUploadID,
+			Filename:    filename,
+			Worker:      worker,
+			Connections: connections,
+			Size:        size,
+			StartedAt:   time.Now(),
+		},
+	}
+	m.active[t.progress.ID] = t
+	return t
+}
+
+// Chunk 在每个分片上传完成后调用，多个分片并行上传时可能乱序
+func (t *uploadTracker) Chunk(_ context.Context, state uploader.ProgressState) error {
+	t.metrics.mutex.Lock()
+	defer t.metrics.mutex.Unlock()
+
+	if delta := state.Uploaded - t.progress.Uploaded; delta > 0 {
+		t.progress.Uploaded = state.Uploaded
+		t.metrics.UploadedBytes += delta
+	}
+	return nil
+}
+
+// 结束跟踪，成功的上传计入平均速度
+func (t *uploadTracker) finish(success bool) {
+	m := t.metrics
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.active, t.progress.ID)
+	if !success {
+		return
+	}
+	m.transferBytes += t.progress.Uploaded
+	m.transferTime += time.Since(t.progress.StartedAt)
+	if m.transferTime > 0 {
+		m.AverageThroughput = float64(m.transferBytes) / m.transferTime.Seconds()
+	}
+}
+
+// 刷新进行中上传的进度和总速度，调用方需持有mutex
+func (m *UploadMetrics) refreshProgress(now time.Time) {
+	m.ActiveUploads = make([]UploadProgress, 0, len(m.active))
+	m.CurrentThroughput = 0
+	for _, t := range m.active {
+		progress := t.progress
+		if progress.Size > 0 {
+			progress.Percent = float64(progress.Uploaded) / float64(progress.Size) * 100
+		}
+		if elapsed := now.Sub(progress.StartedAt).Seconds(); elapsed > 0 {
+			progress.Throughput = float64(progress.Uploaded) / elapsed
+		}
+		m.CurrentThroughput += progress.Throughput
+		m.ActiveUploads = append(m.ActiveUploads, progress)
+	}
+	sort.Slice(m.ActiveUploads, func(i, j int) bool {
+		return m.ActiveUploads[i].ID < m.ActiveUploads[j].ID
+	})
+}
@@ -0,0 +1,76 @@
+package routes
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gotd/td/telegram/uploader"
+)
+
+// TestUploadTracker_Progress 测试分片进度和速度统计
+func TestUploadTracker_Progress(t *testing.T) {
+	metrics := &UploadMetrics{}
+	tracker := metrics.startUpload("big.zip", "MULTI_TOKEN1", 4, 4096)
+
+	// 并行上传时回调可能乱序
+	tracker.Chunk(context.Background(), uploader.ProgressState{Uploaded: 2048})
+	tracker.Chunk(context.Background(), uploader.ProgressState{Uploaded: 1024})
+	tracker.Chunk(context.Background(), uploader.ProgressState{Uploaded: 3072})
+
+	metrics.mutex.Lock()
+	metrics.refreshProgress(tracker.progress.StartedAt.Add(time.Second))
+	active := metrics.ActiveUploads
+	uploaded := metrics.UploadedBytes
+	current := metrics.CurrentThroughput
+	metrics.mutex.Unlock()
+
+	if uploaded != 3072 {
+		t.Errorf("期望 UploadedBytes = 3072, 得到 %d", uploaded)
+	}
+	if len(active) != 1 {
+		t.Fatalf("期望 1 个进行中的上传, 得到 %d", len(active))
+	}
+	if active[0].Percent != 75 {
+		t.Errorf("期望 Percent = 75, 得到 %f", active[0].Percent)
+	}
+	if active[0].Connections != 4 || active[0].Worker != "MULTI_TOKEN1" {
+		t.Errorf("上传信息不正确: %+v", active[0])
+	}
+	if current != 3072 {
+		t.Errorf("期望 CurrentThroughput = 3072, 得到 %f", current)
+	}
+
+	tracker.Chunk(context.Background(), uploader.ProgressState{Uploaded: 4096})
+	tracker.finish(true)
+
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	metrics.refreshProgress(time.Now())
+	if len(metrics.ActiveUploads) != 0 {
+		t.Errorf("完成的上传不应出现在进行中列表")
+	}
+	if metrics.AverageThroughput <= 0 {
+		t.Errorf("期望 AverageThroughput > 0, 得到 %f", metrics.AverageThroughput)
+	}
+}
+
+// TestUploadTracker_Failed 测试失败的上传不计入平均速度
+func TestUploadTracker_Failed(t *testing.T) {
+	metrics := &UploadMetrics{}
+	tracker := metrics.startUpload("big.zip", "default", 1, 4096)
+	tracker.Chunk(context.Background(), uploader.ProgressState{Uploaded: 1024})
+	tracker.finish(false)
+
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	if metrics.UploadedBytes != 1024 {
+		t.Errorf("期望 UploadedBytes = 1024, 得到 %d", metrics.UploadedBytes)
+	}
+	if metrics.AverageThroughput != 0 {
+		t.Errorf("期望 AverageThroughput = 0, 得到 %f", metrics.AverageThroughput)
+	}
+	if len(metrics.active) != 0 {
+		t.Errorf("失败的上传应从进行中列表移除")
+	}
+}
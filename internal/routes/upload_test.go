@@ -13,8 +13,11 @@ import (
 	"testing"
 
 	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/links"
 	"EverythingSuckz/fsb/internal/utils"
 	"github.com/gin-gonic/gin"
+	"github.com/gotd/td/tg"
+	"go.uber.org/zap"
 )
 
 // 测试用的认证令牌
@@ -429,3 +432,68 @@ func TestUploadIntegration(t *testing.T) {
 
 	t.Skip("集成测试需要真实的Telegram客户端和API配置")
 }
+
+// TestUploadResult_LinkHash 测试上传返回的链接能通过/stream的哈希校验，
+// 照片的文件名和大小以Telegram保存的为准
+func TestUploadResult_LinkHash(t *testing.T) {
+	config.ValueOf.HashLength = 6
+	if err := links.Init(zap.NewNop(), filepath.Join(t.TempDir(), "links.db")); err != nil {
+		t.Fatal(err)
+	}
+	photo := &tg.MessageMediaPhoto{Photo: &tg.Photo{
+		ID:         200,
+		AccessHash: 1,
+		Sizes: []tg.PhotoSizeClass{
+			&tg.PhotoSize{Type: "m", W: 320, H: 240, Size: 2000},
+			&tg.PhotoSize{Type: "y", W: 1280, H: 960, Size: 9000},
+		},
+	}}
+	document := &tg.MessageMediaDocument{Document: &tg.Document{
+		ID:       300,
+		Size:     5000,
+		MimeType: "application/pdf",
+		Attributes: []tg.DocumentAttributeClass{
+			&tg.DocumentAttributeFilename{FileName: "report.pdf"},
+		},
+	}}
+
+	tests := []struct {
+		name     string
+		filename string
+		mimeType string
+		size     int64
+		media    tg.MessageMediaClass
+	}{
+		{"照片", "holiday.png", "image/png", 12345, photo},
+		{"文档", "report.pdf", "application/pdf", 5000, document},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messageID := 10 + i
+			update := &tg.Updates{Updates: []tg.UpdateClass{
+				&tg.UpdateMessageID{ID: messageID},
+				&tg.UpdateNewChannelMessage{Message: &tg.Message{ID: messageID, Media: tt.media}},
+			}}
+			header := &multipart.FileHeader{Filename: tt.filename, Size: tt.size, Header: map[string][]string{"Content-Type": {tt.mimeType}}}
+			result, err := uploadResult(update, tt.filename, header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.MessageID != messageID {
+				t.Errorf("期望消息ID %d, 得到 %d", messageID, result.MessageID)
+			}
+			// /stream从消息重新读取文件后校验哈希
+			file, err := utils.FileFromMedia(tt.media)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, status, err := authorizeFile(messageID, file, result.Hash); err != nil {
+				t.Errorf("上传返回的哈希校验失败: %d %v", status, err)
+			}
+		})
+	}
+
+	if _, err := uploadResult(&tg.Updates{}, "a.txt", &multipart.FileHeader{}); err == nil {
+		t.Error("没有消息时期望返回错误")
+	}
+}
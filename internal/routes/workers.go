@@ -0,0 +1,41 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/bot"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (e *allRoutes) LoadWorkers(r *Route) {
+	log := e.log.Named("Workers")
+	defer log.Info("Loaded workers route")
+	r.Engine.GET("/workers/health", requireAdminIfSet, getWorkersHealthRoute)
+}
+
+// requireAdminIfSet requires the ADMIN_TOKEN bearer token if one is set,
+// like /metrics does with METRICS_TOKEN. The worker health has no secrets,
+// proxy passwords are redacted.
+func requireAdminIfSet(ctx *gin.Context) {
+	if config.ValueOf.AdminToken == "" {
+		ctx.Next()
+		return
+	}
+	requireAdmin(ctx)
+}
+
+func getWorkersHealthRoute(ctx *gin.Context) {
+	workers := bot.GetWorkersHealth()
+	healthy := 0
+	for _, worker := range workers {
+		if worker.Healthy {
+			healthy++
+		}
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"total":   len(workers),
+		"healthy": healthy,
+		"workers": workers,
+	})
+}
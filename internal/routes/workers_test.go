@@ -0,0 +1,47 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// TestWorkersHealthRoute_Admin 测试设置ADMIN_TOKEN后/workers/health需要管理员令牌
+func TestWorkersHealthRoute_Admin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	utils.InitLogger(true)
+	defer func() { config.ValueOf.AdminToken = "" }()
+
+	tests := []struct {
+		name   string
+		token  string
+		header string
+		code   int
+	}{
+		{"未设置ADMIN_TOKEN", "", "", http.StatusOK},
+		{"未设置ADMIN_TOKEN时忽略令牌", "", "Bearer anything", http.StatusOK},
+		{"无令牌", "admin-secret", "", http.StatusUnauthorized},
+		{"错误令牌", "admin-secret", "Bearer wrong", http.StatusUnauthorized},
+		{"正确令牌", "admin-secret", "Bearer admin-secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.ValueOf.AdminToken = tt.token
+			router := gin.New()
+			(&allRoutes{log: utils.Logger}).LoadWorkers(&Route{Name: "/", Engine: router})
+			req := httptest.NewRequest(http.MethodGet, "/workers/health", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.code {
+				t.Errorf("期望状态码 %d, 得到 %d", tt.code, w.Code)
+			}
+		})
+	}
+}
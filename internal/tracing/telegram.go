@@ -0,0 +1,51 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/tdp"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Middleware starts a client span for each Telegram RPC, named after the
+// method. RPCs made outside of a trace, like the update polling, are skipped.
+func Middleware(worker string) telegram.Middleware {
+	return telegram.MiddlewareFunc(func(next tg.Invoker) telegram.InvokeFunc {
+		return func(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+			if !trace.SpanContextFromContext(ctx).IsValid() {
+				return next.Invoke(ctx, input, output)
+			}
+			method := rpcMethod(input)
+			ctx, span := Tracer().Start(ctx, "tg "+method,
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(
+					attribute.String("rpc.system", "telegram"),
+					attribute.String("rpc.method", method),
+					attribute.String("fsb.worker", worker),
+				),
+			)
+			err := next.Invoke(ctx, input, output)
+			if rpcErr, ok := tgerr.As(err); ok {
+				span.SetAttributes(
+					attribute.Int("rpc.telegram.code", rpcErr.Code),
+					attribute.String("rpc.telegram.error", rpcErr.Type),
+				)
+			}
+			End(span, err)
+			return err
+		}
+	})
+}
+
+func rpcMethod(input bin.Encoder) string {
+	if t, ok := input.(interface{ TypeInfo() tdp.Type }); ok {
+		return t.TypeInfo().Name
+	}
+	return fmt.Sprintf("%T", input)
+}
@@ -0,0 +1,79 @@
+package tracing
+
+import (
+	"EverythingSuckz/fsb/config"
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const tracerName = "EverythingSuckz/fsb"
+
+var provider *sdktrace.TracerProvider
+
+// Init exports traces over OTLP/HTTP to OTEL_EXPORTER_OTLP_ENDPOINT.
+// Without an endpoint the global no-op tracer is kept.
+func Init(log *zap.Logger) error {
+	log = log.Named("Tracing")
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{},
+	))
+	if config.ValueOf.OtelEndpoint == "" {
+		log.Debug("OTEL_EXPORTER_OTLP_ENDPOINT is not set, tracing disabled")
+		return nil
+	}
+	// The exporter reads the endpoint, headers and TLS settings from the
+	// standard OTEL_EXPORTER_OTLP_* variables.
+	exporter, err := otlptracehttp.New(context.Background())
+	if err != nil {
+		return err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", config.ValueOf.OtelService),
+	))
+	if err != nil {
+		return err
+	}
+	provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	log.Info("Exporting traces", zap.String("endpoint", config.ValueOf.OtelEndpoint))
+	return nil
+}
+
+// Shutdown flushes the spans not exported yet.
+func Shutdown(ctx context.Context) error {
+	if provider == nil {
+		return nil
+	}
+	return provider.Shutdown(ctx)
+}
+
+// Tracer returns the tracer of the global provider.
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// Start starts an internal span.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records err on the span, if any, and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
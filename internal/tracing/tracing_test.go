@@ -0,0 +1,150 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+func setupExporter(t *testing.T) *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+	return exporter
+}
+
+func attr(span tracetest.SpanStub, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestGin(t *testing.T) {
+	exporter := setupExporter(t)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Gin())
+	router.GET("/stream/:messageID", func(ctx *gin.Context) {
+		_, span := Start(ctx.Request.Context(), "child")
+		span.End()
+		ctx.Status(http.StatusPartialContent)
+	})
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/stream/42?hash=abc", nil))
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	child, server := spans[0], spans[1]
+	if server.Name != "GET /stream/:messageID" {
+		t.Errorf("unexpected server span name %q", server.Name)
+	}
+	if server.SpanKind != trace.SpanKindServer {
+		t.Errorf("expected a server span, got %s", server.SpanKind)
+	}
+	if got := attr(server, "http.response.status_code").AsInt64(); got != http.StatusPartialContent {
+		t.Errorf("expected status 206, got %d", got)
+	}
+	if got := attr(server, "url.path").AsString(); got != "/stream/42" {
+		t.Errorf("expected path /stream/42, got %q", got)
+	}
+	if child.Parent.SpanID() != server.SpanContext.SpanID() {
+		t.Error("handler span should be a child of the request span")
+	}
+}
+
+func TestGin_TraceParent(t *testing.T) {
+	exporter := setupExporter(t)
+	Init(zap.NewNop())
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Gin())
+	router.GET("/", func(ctx *gin.Context) { ctx.Status(http.StatusInternalServerError) })
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(spans))
+	}
+	if got := spans[0].SpanContext.TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("trace ID from traceparent not kept, got %s", got)
+	}
+	if spans[0].Status.Code != codes.Error {
+		t.Errorf("expected an error status for a 500, got %s", spans[0].Status.Code)
+	}
+}
+
+type invokerFunc func(ctx context.Context, input bin.Encoder, output bin.Decoder) error
+
+func (f invokerFunc) Invoke(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+	return f(ctx, input, output)
+}
+
+func TestMiddleware(t *testing.T) {
+	exporter := setupExporter(t)
+	var rpcCtx context.Context
+	invoker := Middleware("worker-1").Handle(invokerFunc(func(ctx context.Context, _ bin.Encoder, _ bin.Decoder) error {
+		rpcCtx = ctx
+		return tgerr.New(420, "FLOOD_WAIT_30")
+	}))
+	req := &tg.UploadGetFileRequest{}
+
+	// RPCs outside of a trace are not recorded.
+	_ = invoker.Invoke(context.Background(), req, &tg.UploadFileBox{})
+	if n := len(exporter.GetSpans()); n != 0 {
+		t.Fatalf("expected no spans without a parent, got %d", n)
+	}
+
+	ctx, parent := Start(context.Background(), "parent")
+	err := invoker.Invoke(ctx, req, &tg.UploadFileBox{})
+	parent.End()
+	if err == nil {
+		t.Fatal("expected the RPC error to be returned")
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	rpc := spans[0]
+	if rpc.Name != "tg upload.getFile" {
+		t.Errorf("unexpected RPC span name %q", rpc.Name)
+	}
+	if rpc.Parent.SpanID() != spans[1].SpanContext.SpanID() {
+		t.Error("RPC span should be a child of the caller span")
+	}
+	if trace.SpanContextFromContext(rpcCtx).SpanID() != rpc.SpanContext.SpanID() {
+		t.Error("RPC span should be passed down the chain")
+	}
+	if got := attr(rpc, "fsb.worker").AsString(); got != "worker-1" {
+		t.Errorf("expected worker label worker-1, got %q", got)
+	}
+	if got := attr(rpc, "rpc.telegram.error").AsString(); got != "FLOOD_WAIT" {
+		t.Errorf("expected error type FLOOD_WAIT, got %q", got)
+	}
+	if rpc.Status.Code != codes.Error {
+		t.Errorf("expected an error status, got %s", rpc.Status.Code)
+	}
+}
@@ -11,11 +11,28 @@ import (
 )
 
 type File struct {
-	Location tg.InputFileLocationClass
-	FileSize int64
-	FileName string
-	MimeType string
-	ID       int64
+	Location   tg.InputFileLocationClass
+	FileSize   int64
+	FileName   string
+	MimeType   string
+	ID         int64
+	PhotoSizes []PhotoSize
+	// NameSynthesized is set when the document has no file name and FileName
+	// was made up from its attributes.
+	NameSynthesized bool
+	// Thumbs are the downloadable thumbnails of a document.
+	Thumbs []PhotoSize
+	// StrippedThumb is the inline, header-less JPEG preview of the media.
+	StrippedThumb []byte
+	// ExpiresAt is the unix time the link the file is served through
+	// expires at, 0 if it doesn't.
+	ExpiresAt int64
+}
+
+// PhotoSize is a downloadable size of a photo, e.g. "m" or "y".
+type PhotoSize struct {
+	Type string
+	Size int64
 }
 
 type HashableFileStruct struct {
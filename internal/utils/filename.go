@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"fmt"
+	"mime"
+	"strings"
+
+	"github.com/gotd/td/tg"
+)
+
+// Telegram uses a handful of MIME types that the standard library either
+// doesn't know or maps to an unexpected extension.
+var mimeExtensions = map[string]string{
+	"application/x-tgsticker": ".tgs",
+	"application/pdf":         ".pdf",
+	"application/zip":         ".zip",
+	"audio/mpeg":              ".mp3",
+	"audio/mp4":               ".m4a",
+	"audio/ogg":               ".ogg",
+	"audio/x-flac":            ".flac",
+	"audio/flac":              ".flac",
+	"image/gif":               ".gif",
+	"image/jpeg":              ".jpg",
+	"image/png":               ".png",
+	"image/webp":              ".webp",
+	"video/mp4":               ".mp4",
+	"video/quicktime":         ".mov",
+	"video/webm":              ".webm",
+	"video/x-matroska":        ".mkv",
+}
+
+// ExtensionFromMime returns a file extension (with the leading dot) for the
+// given MIME type, or an empty string if it is unknown.
+func ExtensionFromMime(mimeType string) string {
+	mimeType = strings.ToLower(strings.TrimSpace(strings.Split(mimeType, ";")[0]))
+	if ext, ok := mimeExtensions[mimeType]; ok {
+		return ext
+	}
+	if exts, err := mime.ExtensionsByType(mimeType); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
+
+// DocumentFileName returns the file name of a document, synthesizing one from
+// its attributes and MIME type when DocumentAttributeFilename is missing
+// (voice notes, video notes, stickers, ...).
+func DocumentFileName(document *tg.Document) string {
+	if name := documentAttributeFileName(document); name != "" {
+		return name
+	}
+	kind := "file"
+	title := ""
+	for _, attribute := range document.Attributes {
+		switch attr := attribute.(type) {
+		case *tg.DocumentAttributeAudio:
+			if attr.Voice {
+				kind = "voice"
+				continue
+			}
+			kind = "audio"
+			switch {
+			case attr.Performer != "" && attr.Title != "":
+				title = attr.Performer + " - " + attr.Title
+			case attr.Title != "":
+				title = attr.Title
+			}
+		case *tg.DocumentAttributeVideo:
+			if attr.RoundMessage {
+				kind = "video_note"
+			} else if kind == "file" {
+				kind = "video"
+			}
+		case *tg.DocumentAttributeSticker:
+			kind = "sticker"
+		case *tg.DocumentAttributeAnimated:
+			if kind == "file" || kind == "video" {
+				kind = "animation"
+			}
+		}
+	}
+	ext := ExtensionFromMime(document.MimeType)
+	if title != "" {
+		return SanitizeFilename(title + ext)
+	}
+	return fmt.Sprintf("%s_%d%s", kind, document.ID, ext)
+}
+
+func documentAttributeFileName(document *tg.Document) string {
+	for _, attribute := range document.Attributes {
+		if attr, ok := attribute.(*tg.DocumentAttributeFilename); ok && attr.FileName != "" {
+			return attr.FileName
+		}
+	}
+	return ""
+}
+
+// WebPageMedia returns the document or photo embedded in a web page preview
+// as a regular message media, if there is one.
+func WebPageMedia(media *tg.MessageMediaWebPage) (tg.MessageMediaClass, bool) {
+	page, ok := media.Webpage.(*tg.WebPage)
+	if !ok {
+		return nil, false
+	}
+	if document, ok := page.GetDocument(); ok {
+		if _, ok := document.AsNotEmpty(); ok {
+			return &tg.MessageMediaDocument{Document: document}, true
+		}
+	}
+	if photo, ok := page.GetPhoto(); ok {
+		if _, ok := photo.AsNotEmpty(); ok {
+			return &tg.MessageMediaPhoto{Photo: photo}, true
+		}
+	}
+	return nil, false
+}
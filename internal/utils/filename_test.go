@@ -0,0 +1,158 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/gotd/td/tg"
+)
+
+func TestExtensionFromMime(t *testing.T) {
+	tests := map[string]string{
+		"video/mp4":                 ".mp4",
+		"VIDEO/MP4":                 ".mp4",
+		"audio/ogg; codecs=opus":    ".ogg",
+		"application/x-tgsticker":   ".tgs",
+		"image/svg+xml":             ".svg",
+		"application/x-fsb-unknown": "",
+		"":                          "",
+	}
+	for mimeType, want := range tests {
+		if got := ExtensionFromMime(mimeType); got != want {
+			t.Errorf("ExtensionFromMime(%q) = %q, want %q", mimeType, got, want)
+		}
+	}
+}
+
+func TestDocumentFileName(t *testing.T) {
+	tests := []struct {
+		name       string
+		mimeType   string
+		attributes []tg.DocumentAttributeClass
+		want       string
+	}{
+		{"file name", "video/mp4", []tg.DocumentAttributeClass{
+			&tg.DocumentAttributeVideo{},
+			&tg.DocumentAttributeFilename{FileName: "movie.mkv"},
+		}, "movie.mkv"},
+		{"empty file name", "application/pdf", []tg.DocumentAttributeClass{
+			&tg.DocumentAttributeFilename{},
+		}, "file_42.pdf"},
+		{"voice note", "audio/ogg", []tg.DocumentAttributeClass{
+			&tg.DocumentAttributeAudio{Voice: true, Title: "ignored"},
+		}, "voice_42.ogg"},
+		{"audio with title", "audio/mpeg", []tg.DocumentAttributeClass{
+			&tg.DocumentAttributeAudio{Performer: "Artist", Title: "Song"},
+		}, "Artist - Song.mp3"},
+		{"audio title with slash", "audio/mpeg", []tg.DocumentAttributeClass{
+			&tg.DocumentAttributeAudio{Title: "AC/DC"},
+		}, "AC_DC.mp3"},
+		{"untitled audio", "audio/mp4", []tg.DocumentAttributeClass{
+			&tg.DocumentAttributeAudio{},
+		}, "audio_42.m4a"},
+		{"video note", "video/mp4", []tg.DocumentAttributeClass{
+			&tg.DocumentAttributeVideo{RoundMessage: true},
+		}, "video_note_42.mp4"},
+		{"video", "video/webm", []tg.DocumentAttributeClass{
+			&tg.DocumentAttributeVideo{},
+		}, "video_42.webm"},
+		{"animation", "video/mp4", []tg.DocumentAttributeClass{
+			&tg.DocumentAttributeVideo{},
+			&tg.DocumentAttributeAnimated{},
+		}, "animation_42.mp4"},
+		{"sticker", "image/webp", []tg.DocumentAttributeClass{
+			&tg.DocumentAttributeImageSize{},
+			&tg.DocumentAttributeSticker{},
+		}, "sticker_42.webp"},
+		{"animated sticker", "application/x-tgsticker", []tg.DocumentAttributeClass{
+			&tg.DocumentAttributeSticker{},
+			&tg.DocumentAttributeAnimated{},
+		}, "sticker_42.tgs"},
+		{"video sticker", "video/webm", []tg.DocumentAttributeClass{
+			&tg.DocumentAttributeVideo{},
+			&tg.DocumentAttributeSticker{},
+		}, "sticker_42.webm"},
+		{"unknown MIME type", "application/x-fsb-unknown", nil, "file_42"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			document := &tg.Document{ID: 42, MimeType: tt.mimeType, Attributes: tt.attributes}
+			if got := DocumentFileName(document); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebPageMedia(t *testing.T) {
+	document := &tg.Document{ID: 1}
+	photo := &tg.Photo{ID: 2}
+	tests := []struct {
+		name    string
+		webpage tg.WebPageClass
+		want    tg.MessageMediaClass
+	}{
+		{"document", &tg.WebPage{Document: document, Photo: photo}, &tg.MessageMediaDocument{Document: document}},
+		{"photo", &tg.WebPage{Photo: photo}, &tg.MessageMediaPhoto{Photo: photo}},
+		{"empty document", &tg.WebPage{Document: &tg.DocumentEmpty{ID: 1}, Photo: photo}, &tg.MessageMediaPhoto{Photo: photo}},
+		{"empty photo", &tg.WebPage{Photo: &tg.PhotoEmpty{ID: 2}}, nil},
+		{"no media", &tg.WebPage{}, nil},
+		{"pending", &tg.WebPagePending{}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page := tt.webpage
+			if p, ok := page.(*tg.WebPage); ok {
+				if p.Document != nil {
+					p.SetDocument(p.Document)
+				}
+				if p.Photo != nil {
+					p.SetPhoto(p.Photo)
+				}
+			}
+			got, ok := WebPageMedia(&tg.MessageMediaWebPage{Webpage: page})
+			if ok != (tt.want != nil) {
+				t.Fatalf("got ok %v for %v", ok, got)
+			}
+			switch want := tt.want.(type) {
+			case *tg.MessageMediaDocument:
+				if got, _ := got.(*tg.MessageMediaDocument); got == nil || got.Document != want.Document {
+					t.Errorf("got %v, want the document", got)
+				}
+			case *tg.MessageMediaPhoto:
+				if got, _ := got.(*tg.MessageMediaPhoto); got == nil || got.Photo != want.Photo {
+					t.Errorf("got %v, want the photo", got)
+				}
+			}
+		})
+	}
+}
+
+// Links to documents without a file name were hashed with an empty name
+// before the names were synthesized.
+func TestLegacyHashesSynthesizedName(t *testing.T) {
+	voice := &tg.Document{ID: 42, Size: 1000, MimeType: "audio/ogg", Attributes: []tg.DocumentAttributeClass{
+		&tg.DocumentAttributeAudio{Voice: true},
+	}}
+	file, err := FileFromMedia(&tg.MessageMediaDocument{Document: voice})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !file.NameSynthesized {
+		t.Fatal("voice note name isn't marked as synthesized")
+	}
+	legacy := PackFile("", voice.Size, voice.MimeType, voice.ID)
+	if hashes := LegacyHashes(file); len(hashes) != 1 || hashes[0] != legacy {
+		t.Errorf("got legacy hashes %v, want %v", hashes, legacy)
+	}
+
+	named := &tg.Document{ID: 43, Size: 1000, MimeType: "video/mp4", Attributes: []tg.DocumentAttributeClass{
+		&tg.DocumentAttributeFilename{FileName: "movie.mp4"},
+	}}
+	file, err = FileFromMedia(&tg.MessageMediaDocument{Document: named})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.NameSynthesized || len(LegacyHashes(file)) != 0 {
+		t.Errorf("named document has legacy hashes %v", LegacyHashes(file))
+	}
+}
@@ -3,12 +3,42 @@ package utils
 import (
 	"EverythingSuckz/fsb/config"
 	"EverythingSuckz/fsb/internal/types"
+	"crypto/md5"
+	"encoding/hex"
+	"strconv"
+
+	"github.com/gotd/td/tg"
 )
 
 func PackFile(fileName string, fileSize int64, mimeType string, fileID int64) string {
 	return (&types.HashableFileStruct{FileName: fileName, FileSize: fileSize, MimeType: mimeType, FileID: fileID}).Pack()
 }
 
+// PackLink is PackFile for links that also carry an expiry. Links without an
+// expiry hash exactly like PackFile so existing links keep working.
+func PackLink(fileName string, fileSize int64, mimeType string, fileID int64, expiresAt int64) string {
+	hash := PackFile(fileName, fileSize, mimeType, fileID)
+	if expiresAt == 0 {
+		return hash
+	}
+	sum := md5.Sum([]byte(hash + strconv.FormatInt(expiresAt, 10)))
+	return hex.EncodeToString(sum[:])
+}
+
+// LegacyHashes returns the hashes older versions issued for file, so that
+// the links they sent keep working. Documents without a file name were
+// hashed with an empty name and photos with a size of 0.
+func LegacyHashes(file *types.File) []string {
+	var hashes []string
+	if _, ok := file.Location.(*tg.InputPhotoFileLocation); ok {
+		hashes = append(hashes, PackFile(file.FileName, 0, file.MimeType, file.ID))
+	}
+	if file.NameSynthesized {
+		hashes = append(hashes, PackFile("", file.FileSize, file.MimeType, file.ID))
+	}
+	return hashes
+}
+
 func GetShortHash(fullHash string) string {
 	return fullHash[:config.ValueOf.HashLength]
 }
@@ -0,0 +1,20 @@
+package utils
+
+import "testing"
+
+func TestPackLink(t *testing.T) {
+	plain := PackFile("movie.mkv", 1000, "video/x-matroska", 100)
+	if got := PackLink("movie.mkv", 1000, "video/x-matroska", 100, 0); got != plain {
+		t.Errorf("link without expiry hashes as %s, want the PackFile hash %s", got, plain)
+	}
+	expiring := PackLink("movie.mkv", 1000, "video/x-matroska", 100, 1700000000)
+	if expiring == plain || len(expiring) != len(plain) {
+		t.Errorf("expiring link hashes as %s", expiring)
+	}
+	if later := PackLink("movie.mkv", 1000, "video/x-matroska", 100, 1700000001); later == expiring {
+		t.Error("changing the expiry doesn't change the hash")
+	}
+	if renamed := PackLink("other.mkv", 1000, "video/x-matroska", 100, 1700000000); renamed == expiring {
+		t.Error("changing the name doesn't change the hash")
+	}
+}
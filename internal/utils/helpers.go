@@ -3,16 +3,19 @@ package utils
 import (
 	"EverythingSuckz/fsb/config"
 	"EverythingSuckz/fsb/internal/cache"
+	"EverythingSuckz/fsb/internal/tracing"
 	"EverythingSuckz/fsb/internal/types"
 	"context"
 	"errors"
 	"fmt"
 	"math/rand"
+	"sort"
 
 	"github.com/celestix/gotgproto"
 	"github.com/celestix/gotgproto/ext"
 	"github.com/celestix/gotgproto/storage"
 	"github.com/gotd/td/tg"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 )
 
@@ -53,55 +56,114 @@ func FileFromMedia(media tg.MessageMediaClass) (*types.File, error) {
 		if !ok {
 			return nil, fmt.Errorf("unexpected type %T", media)
 		}
-		var fileName string
-		for _, attribute := range document.Attributes {
-			if name, ok := attribute.(*tg.DocumentAttributeFilename); ok {
-				fileName = name.FileName
-				break
-			}
-		}
+		thumbs, stripped := collectPhotoSizes(document.Thumbs)
 		return &types.File{
-			Location: document.AsInputDocumentFileLocation(),
-			FileSize: document.Size,
-			FileName: fileName,
-			MimeType: document.MimeType,
-			ID:       document.ID,
+			Location:        document.AsInputDocumentFileLocation(),
+			FileSize:        document.Size,
+			FileName:        DocumentFileName(document),
+			MimeType:        document.MimeType,
+			ID:              document.ID,
+			Thumbs:          thumbs,
+			StrippedThumb:   stripped,
+			NameSynthesized: documentAttributeFileName(document) == "",
 		}, nil
 	case *tg.MessageMediaPhoto:
 		photo, ok := media.Photo.AsNotEmpty()
 		if !ok {
 			return nil, fmt.Errorf("unexpected type %T", media)
 		}
-		sizes := photo.Sizes
-		if len(sizes) == 0 {
-			return nil, errors.New("photo has no sizes")
-		}
-		photoSize := sizes[len(sizes)-1]
-		size, ok := photoSize.AsNotEmpty()
+		return fileFromPhoto(photo)
+	case *tg.MessageMediaWebPage:
+		embedded, ok := WebPageMedia(media)
 		if !ok {
-			return nil, errors.New("photo size is empty")
+			return nil, errors.New("web page has no document or photo")
 		}
-		location := new(tg.InputPhotoFileLocation)
-		location.ID = photo.GetID()
-		location.AccessHash = photo.GetAccessHash()
-		location.FileReference = photo.GetFileReference()
-		location.ThumbSize = size.GetType()
-		return &types.File{
-			Location: location,
-			FileSize: 0, // caller should judge if this is a photo or not
-			FileName: fmt.Sprintf("photo_%d.jpg", photo.GetID()),
-			MimeType: "image/jpeg",
-			ID:       photo.GetID(),
-		}, nil
+		return FileFromMedia(embedded)
 	}
 	return nil, fmt.Errorf("unexpected type %T", media)
 }
 
-func FileFromMessage(ctx context.Context, client *gotgproto.Client, messageID int) (*types.File, error) {
+func fileFromPhoto(photo *tg.Photo) (*types.File, error) {
+	if len(photo.Sizes) == 0 {
+		return nil, errors.New("photo has no sizes")
+	}
+	sizes, stripped := collectPhotoSizes(photo.Sizes)
+	if len(sizes) == 0 {
+		return nil, errors.New("photo has no downloadable sizes")
+	}
+	largest := sizes[0]
+	for _, size := range sizes[1:] {
+		if size.Size > largest.Size {
+			largest = size
+		}
+	}
+	location := new(tg.InputPhotoFileLocation)
+	location.ID = photo.GetID()
+	location.AccessHash = photo.GetAccessHash()
+	location.FileReference = photo.GetFileReference()
+	location.ThumbSize = largest.Type
+	return &types.File{
+		Location:      location,
+		FileSize:      largest.Size,
+		FileName:      fmt.Sprintf("photo_%d.jpg", photo.GetID()),
+		MimeType:      "image/jpeg",
+		ID:            photo.GetID(),
+		PhotoSizes:    sizes,
+		StrippedThumb: stripped,
+	}, nil
+}
+
+// collectPhotoSizes returns the downloadable sizes and the stripped preview
+// (if any) out of a photo's sizes or a document's thumbs.
+func collectPhotoSizes(photoSizes []tg.PhotoSizeClass) ([]types.PhotoSize, []byte) {
+	sizes := make([]types.PhotoSize, 0, len(photoSizes))
+	var stripped []byte
+	for _, photoSize := range photoSizes {
+		switch size := photoSize.(type) {
+		case *tg.PhotoSize:
+			sizes = append(sizes, types.PhotoSize{Type: size.Type, Size: int64(size.Size)})
+		case *tg.PhotoSizeProgressive:
+			if len(size.Sizes) == 0 {
+				continue
+			}
+			sizes = append(sizes, types.PhotoSize{Type: size.Type, Size: int64(size.Sizes[len(size.Sizes)-1])})
+		case *tg.PhotoStrippedSize:
+			stripped = size.Bytes
+		}
+	}
+	return sizes, stripped
+}
+
+// PhotoSizeFile returns a copy of a photo file pointing at another of its
+// sizes, identified by its type (s, m, x, y, w, ...). The file name is kept,
+// it may be the one a link was renamed to.
+func PhotoSizeFile(file *types.File, sizeType string) (*types.File, error) {
+	location, ok := file.Location.(*tg.InputPhotoFileLocation)
+	if !ok {
+		return nil, errors.New("size can only be requested for photos")
+	}
+	for _, size := range file.PhotoSizes {
+		if size.Type != sizeType {
+			continue
+		}
+		sized := *file
+		sizedLocation := *location
+		sizedLocation.ThumbSize = size.Type
+		sized.Location = &sizedLocation
+		sized.FileSize = size.Size
+		return &sized, nil
+	}
+	return nil, fmt.Errorf("photo has no size %q", sizeType)
+}
+
+func FileFromMessage(ctx context.Context, client *gotgproto.Client, messageID int) (_ *types.File, err error) {
+	ctx, span := tracing.Start(ctx, "FileFromMessage", attribute.Int("message.id", messageID))
+	defer func() { tracing.End(span, err) }()
 	key := fmt.Sprintf("file:%d:%d", messageID, client.Self.ID)
 	log := Logger.Named("GetMessageMedia")
 	var cachedMedia types.File
-	err := cache.GetCache().Get(key, &cachedMedia)
+	err = cache.GetCache().Get(key, &cachedMedia)
+	span.SetAttributes(attribute.Bool("cache.hit", err == nil))
 	if err == nil {
 		log.Debug("Using cached media message properties", zap.Int("messageID", messageID), zap.Int64("clientID", client.Self.ID))
 		return &cachedMedia, nil
@@ -118,7 +180,7 @@ func FileFromMessage(ctx context.Context, client *gotgproto.Client, messageID in
 	err = cache.GetCache().Set(
 		key,
 		file,
-		3600,
+		config.ValueOf.CacheTTL,
 	)
 	if err != nil {
 		return nil, err
@@ -126,9 +188,13 @@ func FileFromMessage(ctx context.Context, client *gotgproto.Client, messageID in
 	return file, nil
 }
 
-func GetLogChannelPeer(ctx context.Context, api *tg.Client, peerStorage *storage.PeerStorage) (*tg.InputChannel, error) {
+func GetLogChannelPeer(ctx context.Context, api *tg.Client, peerStorage *storage.PeerStorage) (_ *tg.InputChannel, err error) {
+	ctx, span := tracing.Start(ctx, "GetLogChannelPeer")
+	defer func() { tracing.End(span, err) }()
 	cachedInputPeer := peerStorage.GetInputPeerById(config.ValueOf.LogChannelID)
 
+	_, cached := cachedInputPeer.(*tg.InputPeerChannel)
+	span.SetAttributes(attribute.Bool("cache.hit", cached))
 	switch peer := cachedInputPeer.(type) {
 	case *tg.InputPeerEmpty:
 		break
@@ -159,7 +225,7 @@ func GetLogChannelPeer(ctx context.Context, api *tg.Client, peerStorage *storage
 	return channel.AsInput(), nil
 }
 
-func ForwardMessages(ctx *ext.Context, fromChatId, toChatId int64, messageID int) (*tg.Updates, error) {
+func ForwardMessages(ctx *ext.Context, fromChatId, toChatId int64, messageIDs ...int) (*tg.Updates, error) {
 	fromPeer := ctx.PeerStorage.GetInputPeerById(fromChatId)
 	if fromPeer.Zero() {
 		return nil, fmt.Errorf("fromChatId: %d is not a valid peer", fromChatId)
@@ -168,10 +234,14 @@ func ForwardMessages(ctx *ext.Context, fromChatId, toChatId int64, messageID int
 	if err != nil {
 		return nil, err
 	}
+	randomIDs := make([]int64, len(messageIDs))
+	for i := range randomIDs {
+		randomIDs[i] = rand.Int63()
+	}
 	update, err := ctx.Raw.MessagesForwardMessages(ctx, &tg.MessagesForwardMessagesRequest{
-		RandomID: []int64{rand.Int63()},
+		RandomID: randomIDs,
 		FromPeer: fromPeer,
-		ID:       []int{messageID},
+		ID:       messageIDs,
 		ToPeer:   &tg.InputPeerChannel{ChannelID: toPeer.ChannelID, AccessHash: toPeer.AccessHash},
 	})
 	if err != nil {
@@ -179,3 +249,22 @@ func ForwardMessages(ctx *ext.Context, fromChatId, toChatId int64, messageID int
 	}
 	return update.(*tg.Updates), nil
 }
+
+// ForwardedMessages returns the new channel messages contained in the result
+// of ForwardMessages, ordered by message ID.
+func ForwardedMessages(update *tg.Updates) []*tg.Message {
+	messages := make([]*tg.Message, 0, len(update.Updates))
+	for _, upd := range update.Updates {
+		newMessage, ok := upd.(*tg.UpdateNewChannelMessage)
+		if !ok {
+			continue
+		}
+		if msg, ok := newMessage.Message.(*tg.Message); ok {
+			messages = append(messages, msg)
+		}
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].ID < messages[j].ID
+	})
+	return messages
+}
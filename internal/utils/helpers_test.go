@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"EverythingSuckz/fsb/internal/types"
+
+	"github.com/gotd/td/tg"
+)
+
+func TestCollectPhotoSizes(t *testing.T) {
+	sizes, stripped := collectPhotoSizes([]tg.PhotoSizeClass{
+		&tg.PhotoStrippedSize{Type: "i", Bytes: []byte{1, 2, 3}},
+		&tg.PhotoSize{Type: "m", Size: 1200},
+		&tg.PhotoCachedSize{Type: "s", Bytes: []byte{4}},
+		&tg.PhotoSizeProgressive{Type: "y", Sizes: []int{5000, 20000, 80000}},
+		&tg.PhotoSizeProgressive{Type: "w"},
+		&tg.PhotoSizeEmpty{Type: "x"},
+	})
+	want := []types.PhotoSize{{Type: "m", Size: 1200}, {Type: "y", Size: 80000}}
+	if !reflect.DeepEqual(sizes, want) {
+		t.Errorf("got sizes %v, want %v", sizes, want)
+	}
+	if !bytes.Equal(stripped, []byte{1, 2, 3}) {
+		t.Errorf("got stripped size %v", stripped)
+	}
+}
+
+func testPhoto() *tg.Photo {
+	return &tg.Photo{ID: 7, AccessHash: 8, FileReference: []byte{9}, Sizes: []tg.PhotoSizeClass{
+		&tg.PhotoSize{Type: "s", Size: 800},
+		&tg.PhotoSizeProgressive{Type: "y", Sizes: []int{1000, 40000}},
+		&tg.PhotoSize{Type: "m", Size: 9000},
+	}}
+}
+
+func TestFileFromPhoto(t *testing.T) {
+	file, err := FileFromMedia(&tg.MessageMediaPhoto{Photo: testPhoto()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	location := file.Location.(*tg.InputPhotoFileLocation)
+	if location.ThumbSize != "y" || file.FileSize != 40000 {
+		t.Errorf("got size %q of %d bytes, want the largest", location.ThumbSize, file.FileSize)
+	}
+	if file.FileName != "photo_7.jpg" || file.MimeType != "image/jpeg" {
+		t.Errorf("got %s %s", file.FileName, file.MimeType)
+	}
+
+	_, err = FileFromMedia(&tg.MessageMediaPhoto{Photo: &tg.Photo{ID: 7, Sizes: []tg.PhotoSizeClass{
+		&tg.PhotoStrippedSize{Type: "i", Bytes: []byte{1}},
+	}}})
+	if err == nil {
+		t.Error("photo without downloadable sizes was accepted")
+	}
+}
+
+func TestPhotoSizeFile(t *testing.T) {
+	file, err := FileFromMedia(&tg.MessageMediaPhoto{Photo: testPhoto()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sized, err := PhotoSizeFile(file, "s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sized.Location.(*tg.InputPhotoFileLocation).ThumbSize != "s" || sized.FileSize != 800 || sized.FileName != "photo_7.jpg" {
+		t.Errorf("got %+v", sized)
+	}
+	// the name of a renamed link is kept
+	renamed := *file
+	renamed.FileName = "holiday.jpg"
+	if sized, err := PhotoSizeFile(&renamed, "s"); err != nil || sized.FileName != "holiday.jpg" {
+		t.Errorf("got %+v, %v", sized, err)
+	}
+	if file.Location.(*tg.InputPhotoFileLocation).ThumbSize != "y" || file.FileSize != 40000 {
+		t.Error("PhotoSizeFile changed the original file")
+	}
+
+	if _, err := PhotoSizeFile(file, "z"); err == nil {
+		t.Error("unknown size was accepted")
+	}
+	document := &types.File{Location: &tg.InputDocumentFileLocation{ID: 1}}
+	if _, err := PhotoSizeFile(document, "s"); err == nil {
+		t.Error("size of a document was accepted")
+	}
+}
+
+// Photos were hashed with a size of 0 before their real size was known.
+func TestLegacyHashesPhoto(t *testing.T) {
+	file, err := FileFromMedia(&tg.MessageMediaPhoto{Photo: testPhoto()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := PackFile("photo_7.jpg", 0, "image/jpeg", 7)
+	if hashes := LegacyHashes(file); len(hashes) != 1 || hashes[0] != legacy {
+		t.Errorf("got legacy hashes %v, want %v", hashes, legacy)
+	}
+}
@@ -1,8 +1,11 @@
 package utils
 
 import (
+	"fmt"
 	"os"
-	"time"
+	"sort"
+	"strings"
+	"sync/atomic"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -11,38 +14,237 @@ import (
 
 var Logger *zap.Logger
 
-func InitLogger(debugMode bool) {
-	customTimeEncoder := func(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
-		enc.AppendString(t.Format("02/01/2006 03:04 PM"))
-	}
-	consoleConfig := zap.NewDevelopmentEncoderConfig()
-	consoleConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
-	consoleConfig.EncodeTime = customTimeEncoder
-	consoleEncoder := zapcore.NewConsoleEncoder(consoleConfig)
-
-	fileEncoderConfig := zap.NewProductionEncoderConfig()
-	fileEncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
-	fileEncoder := zapcore.NewJSONEncoder(fileEncoderConfig)
-
-	fileWriter := zapcore.AddSync(&lumberjack.Logger{
-		Filename:   "logs/app.log",
-		MaxSize:    10,
-		MaxBackups: 3,
-		MaxAge:     7,
-		Compress:   true,
-	})
+// AccessLogger writes one JSON entry per stream or upload request, see
+// InitAccessLogger. It discards everything until then.
+var AccessLogger = zap.NewNop()
+
+// DefaultLogTimeFormat is the time layout of the console output.
+const DefaultLogTimeFormat = "02/01/2006 03:04 PM"
+
+// LogOptions configures the outputs of Logger, see ConfigureLogger.
+type LogOptions struct {
+	// Level is a level optionally followed by per-component levels, like
+	// "info,Stream=debug,Workers=warn".
+	Level string
+	// Format of the stdout output: console or json.
+	Format     string
+	TimeFormat string
+	// File receives the JSON log, rotated after MaxSize MB. No file is
+	// written if empty.
+	File       string
+	MaxSize    int
+	MaxBackups int
+	MaxAge     int
+}
+
+var (
+	logSinks  atomic.Pointer[zapcore.Core]
+	logLevels atomic.Pointer[LogLevels]
+)
 
-	var consoleLevel zapcore.Level
+// InitLogger logs to stdout until ConfigureLogger is called with the
+// loaded config.
+func InitLogger(debugMode bool) {
+	level := "info"
 	if debugMode {
-		consoleLevel = zapcore.DebugLevel
-	} else {
-		consoleLevel = zapcore.InfoLevel
+		level = "debug"
+	}
+	if err := ConfigureLogger(LogOptions{Level: level}); err != nil {
+		panic(err)
+	}
+	Logger = zap.New(&logCore{}, zap.AddStacktrace(zapcore.FatalLevel))
+}
+
+// ConfigureLogger replaces the outputs and levels of Logger, including the
+// loggers already derived from it.
+func ConfigureLogger(opts LogOptions) error {
+	levels, err := ParseLogLevels(opts.Level)
+	if err != nil {
+		return err
+	}
+	if opts.TimeFormat == "" {
+		opts.TimeFormat = DefaultLogTimeFormat
+	}
+
+	var stdoutEncoder zapcore.Encoder
+	switch strings.ToLower(opts.Format) {
+	case "", "console":
+		consoleConfig := zap.NewDevelopmentEncoderConfig()
+		consoleConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
+		consoleConfig.EncodeTime = zapcore.TimeEncoderOfLayout(opts.TimeFormat)
+		stdoutEncoder = zapcore.NewConsoleEncoder(consoleConfig)
+	case "json":
+		stdoutEncoder = zapcore.NewJSONEncoder(jsonEncoderConfig())
+	default:
+		return fmt.Errorf("unknown log format %q, expected console or json", opts.Format)
+	}
+	// Levels are checked by logCore, the sinks take everything
+	cores := []zapcore.Core{
+		zapcore.NewCore(stdoutEncoder, zapcore.AddSync(os.Stdout), zapcore.DebugLevel),
 	}
+	if opts.File != "" {
+		fileWriter := rotatingFile(opts.File, opts)
+		cores = append(cores, zapcore.NewCore(zapcore.NewJSONEncoder(jsonEncoderConfig()), fileWriter, zapcore.DebugLevel))
+	}
+	sinks := zapcore.NewTee(cores...)
+	logSinks.Store(&sinks)
+	logLevels.Store(levels)
+	return nil
+}
 
-	core := zapcore.NewTee(
-		zapcore.NewCore(consoleEncoder, zapcore.AddSync(os.Stdout), consoleLevel),
-		zapcore.NewCore(fileEncoder, fileWriter, zapcore.DebugLevel),
-	)
+func jsonEncoderConfig() zapcore.EncoderConfig {
+	encoderConfig := zap.NewProductionEncoderConfig()
+	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	return encoderConfig
+}
+
+// LogLevels is the default log level and the levels of single components.
+type LogLevels struct {
+	Default    zapcore.Level
+	Components map[string]zapcore.Level
+	min        zapcore.Level
+}
 
-	Logger = zap.New(core, zap.AddStacktrace(zapcore.FatalLevel))
+// ParseLogLevels parses a spec like "info,Stream=debug,Workers=warn".
+// Component names are matched case-insensitively against the logger names.
+func ParseLogLevels(spec string) (*LogLevels, error) {
+	levels := &LogLevels{Default: zapcore.InfoLevel, Components: map[string]zapcore.Level{}}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, levelText, isComponent := strings.Cut(part, "=")
+		if !isComponent {
+			levelText = name
+		}
+		levelText = strings.TrimSpace(levelText)
+		if levelText == "" {
+			return nil, fmt.Errorf("invalid log level %q: missing level", part)
+		}
+		level, err := zapcore.ParseLevel(levelText)
+		if err != nil {
+			return nil, fmt.Errorf("invalid log level %q: %w", part, err)
+		}
+		if !isComponent {
+			levels.Default = level
+			continue
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			return nil, fmt.Errorf("invalid log level %q: missing component", part)
+		}
+		levels.Components[name] = level
+	}
+	levels.min = levels.Default
+	for _, level := range levels.Components {
+		levels.min = min(levels.min, level)
+	}
+	return levels, nil
+}
+
+// Level returns the level of a logger. The whole dotted name is tried
+// first, then its parts from the innermost one, so "Stream=debug" applies
+// to "routes.Stream".
+func (l *LogLevels) Level(loggerName string) zapcore.Level {
+	if len(l.Components) == 0 || loggerName == "" {
+		return l.Default
+	}
+	name := strings.ToLower(loggerName)
+	if level, ok := l.Components[name]; ok {
+		return level
+	}
+	parts := strings.Split(name, ".")
+	for i := len(parts) - 1; i >= 0; i-- {
+		if level, ok := l.Components[parts[i]]; ok {
+			return level
+		}
+	}
+	return l.Default
+}
+
+// String formats the levels the way ParseLogLevels reads them.
+func (l *LogLevels) String() string {
+	parts := []string{l.Default.String()}
+	names := make([]string, 0, len(l.Components))
+	for name := range l.Components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		parts = append(parts, name+"="+l.Components[name].String())
+	}
+	return strings.Join(parts, ",")
+}
+
+// GetLogLevels returns the levels in use.
+func GetLogLevels() *LogLevels {
+	return logLevels.Load()
+}
+
+// SetLogLevels changes the levels at runtime.
+func SetLogLevels(spec string) (*LogLevels, error) {
+	levels, err := ParseLogLevels(spec)
+	if err != nil {
+		return nil, err
+	}
+	logLevels.Store(levels)
+	return levels, nil
+}
+
+// logCore filters entries by the level of their logger and writes them to
+// the current sinks, so that ConfigureLogger and SetLogLevels also apply to
+// the loggers created before.
+type logCore struct {
+	fields []zapcore.Field
+}
+
+func (c *logCore) Enabled(level zapcore.Level) bool {
+	return level >= logLevels.Load().min
+}
+
+func (c *logCore) With(fields []zapcore.Field) zapcore.Core {
+	return &logCore{fields: append(c.fields[:len(c.fields):len(c.fields)], fields...)}
+}
+
+func (c *logCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if entry.Level >= logLevels.Load().Level(entry.LoggerName) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c *logCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	if len(c.fields) > 0 {
+		fields = append(c.fields[:len(c.fields):len(c.fields)], fields...)
+	}
+	return (*logSinks.Load()).Write(entry, fields)
+}
+
+func (c *logCore) Sync() error {
+	return (*logSinks.Load()).Sync()
+}
+
+// InitAccessLogger sends the access log to a JSON file at path, separate
+// from the app log and rotated like it with the MaxSize, MaxBackups and
+// MaxAge of opts. An empty path disables it.
+func InitAccessLogger(path string, opts LogOptions) {
+	if path == "" {
+		AccessLogger = zap.NewNop()
+		return
+	}
+	writer := rotatingFile(path, opts)
+	AccessLogger = zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(jsonEncoderConfig()), writer, zapcore.InfoLevel))
+}
+
+// rotatingFile is a log file rotated after opts.MaxSize MB, keeping
+// opts.MaxBackups compressed files for up to opts.MaxAge days.
+func rotatingFile(path string, opts LogOptions) zapcore.WriteSyncer {
+	return zapcore.AddSync(&lumberjack.Logger{
+		Filename:   path,
+		MaxSize:    opts.MaxSize,
+		MaxBackups: opts.MaxBackups,
+		MaxAge:     opts.MaxAge,
+		Compress:   true,
+	})
 }
@@ -0,0 +1,106 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestParseLogLevels(t *testing.T) {
+	levels, err := ParseLogLevels("warn, Stream=debug,routes.Upload=error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		logger string
+		want   zapcore.Level
+	}{
+		{"", zapcore.WarnLevel},
+		{"Main", zapcore.WarnLevel},
+		{"routes.Stream", zapcore.DebugLevel},
+		{"routes.stream", zapcore.DebugLevel},
+		{"routes.Upload", zapcore.ErrorLevel},
+		{"Upload", zapcore.WarnLevel},
+	}
+	for _, tt := range tests {
+		if got := levels.Level(tt.logger); got != tt.want {
+			t.Errorf("Level(%q) = %s, want %s", tt.logger, got, tt.want)
+		}
+	}
+	if got := levels.String(); got != "warn,routes.upload=error,stream=debug" {
+		t.Errorf("unexpected String() %q", got)
+	}
+
+	for _, spec := range []string{"loud", "Stream=", "=debug"} {
+		if _, err := ParseLogLevels(spec); err == nil {
+			t.Errorf("expected an error for %q", spec)
+		}
+	}
+}
+
+func TestConfigureLogger(t *testing.T) {
+	InitLogger(false)
+	t.Cleanup(func() { InitLogger(false) })
+	// Loggers made before the config is loaded follow it too
+	log := Logger.Named("routes").Named("Stream")
+
+	file := filepath.Join(t.TempDir(), "app.log")
+	err := ConfigureLogger(LogOptions{Level: "info", Format: "json", File: file, MaxSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.Debug("hidden")
+	if _, err := SetLogLevels("info,Stream=debug"); err != nil {
+		t.Fatal(err)
+	}
+	log.With(zap.Int("messageID", 1)).Debug("shown")
+	Logger.Named("Main").Debug("hidden too")
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 line, got %d: %s", len(lines), data)
+	}
+	for _, want := range []string{`"logger":"routes.Stream"`, `"msg":"shown"`, `"messageID":1`} {
+		if !strings.Contains(lines[0], want) {
+			t.Errorf("expected %s in %s", want, lines[0])
+		}
+	}
+
+	if err := ConfigureLogger(LogOptions{Format: "xml"}); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+// The access log is rotated with the LOG_MAX_* settings of the app log.
+func TestInitAccessLogger(t *testing.T) {
+	prev := AccessLogger
+	t.Cleanup(func() { AccessLogger = prev })
+	dir := t.TempDir()
+	InitAccessLogger(filepath.Join(dir, "access.log"), LogOptions{MaxSize: 1, MaxBackups: 1, MaxAge: 1})
+	// a bit more than MaxSize
+	path := strings.Repeat("x", 1024)
+	for i := 0; i < 1100; i++ {
+		AccessLogger.Info("request", zap.String("path", path))
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		backups, _ := filepath.Glob(filepath.Join(dir, "access-*.log.gz"))
+		if len(backups) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			entries, _ := os.ReadDir(dir)
+			t.Fatalf("expected a compressed backup after 1 MB, got %v", entries)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,223 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	proxyCheckInterval = 30 * time.Second
+	proxyCheckTimeout  = 5 * time.Second
+	proxyMinBackoff    = 10 * time.Second
+	proxyMaxBackoff    = 5 * time.Minute
+)
+
+// proxyEntry is a proxy shared by every pool using it, so a proxy found
+// down by one client is skipped by the others.
+type proxyEntry struct {
+	url  string
+	addr string
+	dial DialFunc
+
+	mut       sync.Mutex
+	failures  int
+	downUntil time.Time
+	lastError string
+}
+
+func (e *proxyEntry) up(now time.Time) bool {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	return !now.Before(e.downUntil)
+}
+
+func (e *proxyEntry) markUp() {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	e.failures = 0
+	e.downUntil = time.Time{}
+	e.lastError = ""
+}
+
+// markDown takes the proxy out of rotation with an exponential backoff and
+// reports whether it was up before.
+func (e *proxyEntry) markDown(err error) bool {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	now := time.Now()
+	wasUp := !now.Before(e.downUntil)
+	backoff := proxyMinBackoff << min(e.failures, 10)
+	if backoff > proxyMaxBackoff {
+		backoff = proxyMaxBackoff
+	}
+	e.failures++
+	e.downUntil = now.Add(backoff)
+	e.lastError = err.Error()
+	return wasUp
+}
+
+var (
+	proxyEntries   = make(map[string]*proxyEntry)
+	proxyEntriesMu sync.Mutex
+	proxyCheckOnce sync.Once
+)
+
+func getProxyEntry(proxyURL string) (*proxyEntry, error) {
+	proxyEntriesMu.Lock()
+	defer proxyEntriesMu.Unlock()
+	if entry, ok := proxyEntries[proxyURL]; ok {
+		return entry, nil
+	}
+	if IsMTProxyURL(proxyURL) {
+		return nil, errors.New("MTProxy links can't be used in a proxy list")
+	}
+	if err := ValidateProxyURL(proxyURL); err != nil {
+		return nil, err
+	}
+	dial, err := CreateProxyDialer(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	u, _ := url.Parse(proxyURL)
+	addr := u.Host
+	if u.Port() == "" {
+		port := "1080"
+		switch u.Scheme {
+		case "http":
+			port = "80"
+		case "https":
+			port = "443"
+		}
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	entry := &proxyEntry{url: proxyURL, addr: addr, dial: dial}
+	proxyEntries[proxyURL] = entry
+	return entry, nil
+}
+
+// SplitProxyList splits a comma separated list of proxy URLs.
+func SplitProxyList(proxies string) []string {
+	var list []string
+	for _, proxyURL := range strings.Split(proxies, ",") {
+		if proxyURL = strings.TrimSpace(proxyURL); proxyURL != "" {
+			list = append(list, proxyURL)
+		}
+	}
+	return list
+}
+
+// ProxyPool dials through the first reachable proxy of a list, failing over
+// to the next ones when a proxy can't be reached.
+type ProxyPool struct {
+	entries []*proxyEntry
+}
+
+// NewProxyPool creates a pool of SOCKS5 and HTTP CONNECT proxies, tried in
+// the given order. Unreachable proxies are checked in the background and
+// brought back once they respond again.
+func NewProxyPool(proxyURLs []string) (*ProxyPool, error) {
+	if len(proxyURLs) == 0 {
+		return nil, errors.New("empty proxy list")
+	}
+	pool := &ProxyPool{}
+	for _, proxyURL := range proxyURLs {
+		entry, err := getProxyEntry(proxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", RedactProxyURL(proxyURL), err)
+		}
+		pool.entries = append(pool.entries, entry)
+	}
+	proxyCheckOnce.Do(func() {
+		go checkProxiesPeriodically(proxyCheckInterval)
+	})
+	return pool, nil
+}
+
+// DialContext dials addr through the first proxy that is up. If every proxy
+// is down they are all tried anyway, in order.
+func (p *ProxyPool) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	now := time.Now()
+	ordered := make([]*proxyEntry, 0, len(p.entries))
+	var down []*proxyEntry
+	for _, entry := range p.entries {
+		if entry.up(now) {
+			ordered = append(ordered, entry)
+		} else {
+			down = append(down, entry)
+		}
+	}
+	ordered = append(ordered, down...)
+
+	var errs []error
+	for _, entry := range ordered {
+		conn, err := entry.dial(ctx, network, addr)
+		if err == nil {
+			entry.markUp()
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", RedactProxyURL(entry.url), err))
+		// the proxy answered, the destination is the problem
+		if !errors.Is(err, ErrProxyUnreachable) {
+			return nil, errors.Join(errs...)
+		}
+		if entry.markDown(err) && Logger != nil {
+			Logger.Named("Proxy").Warn("Proxy is unreachable, failing over",
+				zap.String("proxy", RedactProxyURL(entry.url)), zap.Error(err))
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// CheckProxies connects to every known proxy that is down and puts the ones
+// that respond back in rotation.
+func CheckProxies(ctx context.Context) {
+	proxyEntriesMu.Lock()
+	entries := make([]*proxyEntry, 0, len(proxyEntries))
+	for _, entry := range proxyEntries {
+		entries = append(entries, entry)
+	}
+	proxyEntriesMu.Unlock()
+
+	now := time.Now()
+	var wg sync.WaitGroup
+	for _, entry := range entries {
+		if entry.up(now) {
+			continue
+		}
+		wg.Add(1)
+		go func(entry *proxyEntry) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, proxyCheckTimeout)
+			defer cancel()
+			conn, err := proxyForwarder{}.DialContext(checkCtx, "tcp", entry.addr)
+			if err != nil {
+				return
+			}
+			conn.Close()
+			entry.markUp()
+			if Logger != nil {
+				Logger.Named("Proxy").Info("Proxy is reachable again", zap.String("proxy", RedactProxyURL(entry.url)))
+			}
+		}(entry)
+	}
+	wg.Wait()
+}
+
+func checkProxiesPeriodically(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		CheckProxies(context.Background())
+	}
+}
@@ -1,12 +1,14 @@
 package utils
 
 import (
+	"EverythingSuckz/fsb/internal/tracing"
 	"context"
 	"fmt"
 	"io"
 
 	"github.com/celestix/gotgproto"
 	"github.com/gotd/td/tg"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 )
 
@@ -82,7 +84,12 @@ func (r *telegramReader) Read(p []byte) (n int, err error) {
 	return n, nil
 }
 
-func (r *telegramReader) chunk(offset int64, limit int64) ([]byte, error) {
+func (r *telegramReader) chunk(offset int64, limit int64) (_ []byte, err error) {
+	ctx, span := tracing.Start(r.ctx, "telegramReader.chunk",
+		attribute.Int64("chunk.offset", offset),
+		attribute.Int64("chunk.limit", limit),
+	)
+	defer func() { tracing.End(span, err) }()
 
 	req := &tg.UploadGetFileRequest{
 		Offset:   offset,
@@ -90,7 +97,7 @@ func (r *telegramReader) chunk(offset int64, limit int64) ([]byte, error) {
 		Location: r.location,
 	}
 
-	res, err := r.client.API().UploadGetFile(r.ctx, req)
+	res, err := r.client.API().UploadGetFile(ctx, req)
 
 	if err != nil {
 		return nil, err
@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"EverythingSuckz/fsb/internal/types"
+	"errors"
+	"fmt"
+
+	"github.com/gotd/td/telegram/thumbnail"
+	"github.com/gotd/td/tg"
+)
+
+// ErrNoThumbnail is returned when a file has no downloadable thumbnail.
+var ErrNoThumbnail = errors.New("file has no thumbnail")
+
+// defaultPhotoThumb is the photo size served as a thumbnail when none is
+// requested explicitly (a 320px box).
+const defaultPhotoThumb = "m"
+
+// ThumbFile returns a file pointing at a thumbnail of the given photo or
+// document. An empty sizeType picks a sensible default.
+func ThumbFile(file *types.File, sizeType string) (*types.File, error) {
+	switch location := file.Location.(type) {
+	case *tg.InputPhotoFileLocation:
+		if len(file.PhotoSizes) == 0 {
+			return nil, ErrNoThumbnail
+		}
+		if sizeType == "" {
+			sizeType = file.PhotoSizes[0].Type
+			for _, size := range file.PhotoSizes {
+				if size.Type == defaultPhotoThumb {
+					sizeType = size.Type
+					break
+				}
+			}
+		}
+		return PhotoSizeFile(file, sizeType)
+	case *tg.InputDocumentFileLocation:
+		if len(file.Thumbs) == 0 {
+			return nil, ErrNoThumbnail
+		}
+		thumb := file.Thumbs[0]
+		found := sizeType == ""
+		for _, size := range file.Thumbs {
+			if sizeType == "" && size.Size > thumb.Size {
+				thumb = size
+			}
+			if sizeType != "" && size.Type == sizeType {
+				thumb, found = size, true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("document has no thumbnail %q", sizeType)
+		}
+		thumbLocation := *location
+		thumbLocation.ThumbSize = thumb.Type
+		return &types.File{
+			Location: &thumbLocation,
+			FileSize: thumb.Size,
+			FileName: fmt.Sprintf("thumb_%d_%s.jpg", file.ID, thumb.Type),
+			MimeType: "image/jpeg",
+			ID:       file.ID,
+		}, nil
+	}
+	return nil, ErrNoThumbnail
+}
+
+// StrippedThumbJPEG expands the stripped preview of a file into a JPEG.
+func StrippedThumbJPEG(file *types.File) ([]byte, error) {
+	if len(file.StrippedThumb) == 0 {
+		return nil, ErrNoThumbnail
+	}
+	return thumbnail.Expand(file.StrippedThumb)
+}
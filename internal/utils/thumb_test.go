@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"image/jpeg"
+	"testing"
+
+	"EverythingSuckz/fsb/internal/types"
+
+	"github.com/gotd/td/tg"
+)
+
+// strippedThumb is a 40x14 stripped preview.
+var strippedThumb = []byte{
+	0x01, 0x0e, 0x28, 0xa3, 0x9e, 0x05, 0x26, 0x78, 0xa5, 0x03, 0x8e, 0xb4, 0xd2, 0x31, 0x40, 0x06,
+	0x7d, 0x85, 0x19, 0xa4, 0xe2, 0x8e, 0x28, 0x00, 0xa2, 0x8a, 0x28, 0x03,
+}
+
+func TestStrippedThumbJPEG(t *testing.T) {
+	file, err := FileFromMedia(&tg.MessageMediaDocument{Document: &tg.Document{
+		ID: 1,
+		Thumbs: []tg.PhotoSizeClass{
+			&tg.PhotoStrippedSize{Type: "i", Bytes: strippedThumb},
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := StrippedThumbJPEG(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("not a valid JPEG: %v", err)
+	}
+	if config.Width != 40 || config.Height != 14 {
+		t.Errorf("got a %dx%d JPEG, want 40x14", config.Width, config.Height)
+	}
+
+	if _, err := StrippedThumbJPEG(&types.File{}); !errors.Is(err, ErrNoThumbnail) {
+		t.Errorf("got %v for a file without preview", err)
+	}
+}
+
+func TestThumbFile(t *testing.T) {
+	photo := &types.File{
+		Location:   &tg.InputPhotoFileLocation{ID: 2, ThumbSize: "y"},
+		ID:         2,
+		MimeType:   "image/jpeg",
+		PhotoSizes: []types.PhotoSize{{Type: "s", Size: 100}, {Type: "m", Size: 300}, {Type: "y", Size: 900}},
+	}
+	smallPhoto := &types.File{
+		Location:   &tg.InputPhotoFileLocation{ID: 3, ThumbSize: "x"},
+		ID:         3,
+		MimeType:   "image/jpeg",
+		PhotoSizes: []types.PhotoSize{{Type: "s", Size: 100}, {Type: "x", Size: 500}},
+	}
+	document := &types.File{
+		Location: &tg.InputDocumentFileLocation{ID: 4},
+		ID:       4,
+		Thumbs:   []types.PhotoSize{{Type: "s", Size: 100}, {Type: "m", Size: 400}, {Type: "x", Size: 200}},
+	}
+	tests := []struct {
+		name     string
+		file     *types.File
+		sizeType string
+		want     string
+		wantSize int64
+	}{
+		{"photo default", photo, "", "m", 300},
+		{"photo without default size", smallPhoto, "", "s", 100},
+		{"photo size", photo, "y", "y", 900},
+		{"document largest", document, "", "m", 400},
+		{"document size", document, "x", "x", 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			thumb, err := ThumbFile(tt.file, tt.sizeType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got string
+			switch location := thumb.Location.(type) {
+			case *tg.InputPhotoFileLocation:
+				got = location.ThumbSize
+			case *tg.InputDocumentFileLocation:
+				got = location.ThumbSize
+			}
+			if got != tt.want || thumb.FileSize != tt.wantSize || thumb.MimeType != "image/jpeg" {
+				t.Errorf("got size %q of %d bytes, want %q of %d", got, thumb.FileSize, tt.want, tt.wantSize)
+			}
+		})
+	}
+
+	if _, err := ThumbFile(document, "y"); err == nil || errors.Is(err, ErrNoThumbnail) {
+		t.Errorf("got %v for an unknown document thumbnail", err)
+	}
+	if _, err := ThumbFile(photo, "w"); err == nil {
+		t.Error("unknown photo size was accepted")
+	}
+	noThumbs := &types.File{Location: &tg.InputDocumentFileLocation{ID: 5}}
+	if _, err := ThumbFile(noThumbs, ""); !errors.Is(err, ErrNoThumbnail) {
+		t.Errorf("got %v for a document without thumbnails", err)
+	}
+}
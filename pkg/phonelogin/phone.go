@@ -0,0 +1,207 @@
+// This file is a part of EverythingSuckz/TG-FileStreamBot
+// And is licenced under the Affero General Public License.
+// Any distributions of this code MUST be accompanied by a copy of the AGPL
+// with proper attribution to the original author(s).
+
+package phonelogin
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+
+	"EverythingSuckz/fsb/pkg/sessionstring"
+
+	"github.com/gotd/td/session"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/telegram/auth"
+	"github.com/gotd/td/tg"
+	"golang.org/x/term"
+)
+
+// Env variables answering the prompts of the phone login.
+const (
+	PhoneEnv    = "FSB_PHONE"
+	CodeEnv     = "FSB_CODE"
+	PasswordEnv = "FSB_PASSWORD"
+)
+
+// errNoAnswer is returned when a prompt gets an empty answer.
+var errNoAnswer = errors.New("no answer given")
+
+// Authenticator answers the phone login prompts. Answers set in the
+// FSB_PHONE, FSB_CODE and FSB_PASSWORD env variables are used as is, the
+// others are read from In one line each. Prompts are only written to Out if
+// Interactive is set, so that scripts can pipe the answers in.
+type Authenticator struct {
+	In          io.Reader
+	Out         io.Writer
+	Interactive bool
+	// LookupEnv defaults to os.LookupEnv.
+	LookupEnv func(key string) (string, bool)
+	// ReadPassword reads the 2FA password without echoing it, instead of
+	// reading it from In. It is set when stdin is a terminal.
+	ReadPassword func() ([]byte, error)
+
+	reader *bufio.Reader
+}
+
+var _ auth.UserAuthenticator = (*Authenticator)(nil)
+
+func (a *Authenticator) ask(ctx context.Context, env, prompt string) (string, error) {
+	return a.askWith(ctx, env, prompt, a.readLine)
+}
+
+// askWith answers a prompt with env or else with read.
+func (a *Authenticator) askWith(ctx context.Context, env, prompt string, read func() (string, error)) (string, error) {
+	lookupEnv := a.LookupEnv
+	if lookupEnv == nil {
+		lookupEnv = os.LookupEnv
+	}
+	if value, ok := lookupEnv(env); ok && value != "" {
+		return strings.TrimSpace(value), nil
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if a.Interactive {
+		fmt.Fprint(a.Out, prompt)
+	}
+	line, err := read()
+	line = strings.TrimSpace(line)
+	if line == "" {
+		if err == nil || errors.Is(err, io.EOF) {
+			return "", fmt.Errorf("%w, set %s or write it to stdin", errNoAnswer, env)
+		}
+		return "", err
+	}
+	return line, nil
+}
+
+func (a *Authenticator) readLine() (string, error) {
+	if a.reader == nil {
+		a.reader = bufio.NewReader(a.In)
+	}
+	return a.reader.ReadString('\n')
+}
+
+func (a *Authenticator) Phone(ctx context.Context) (string, error) {
+	return a.ask(ctx, PhoneEnv, "Enter your phone number in international format (e.g. +14155552671): ")
+}
+
+func (a *Authenticator) Code(ctx context.Context, sentCode *tg.AuthSentCode) (string, error) {
+	return a.ask(ctx, CodeEnv, fmt.Sprintf("Enter the login code sent %s: ", codeDestination(sentCode)))
+}
+
+func (a *Authenticator) Password(ctx context.Context) (string, error) {
+	read := a.readLine
+	if a.ReadPassword != nil {
+		read = func() (string, error) {
+			password, err := a.ReadPassword()
+			// the newline typed isn't echoed either
+			fmt.Fprintln(a.Out)
+			return string(password), err
+		}
+	}
+	password, err := a.askWith(ctx, PasswordEnv, "2FA password is required, enter it: ", read)
+	if errors.Is(err, errNoAnswer) {
+		return "", auth.ErrPasswordNotProvided
+	}
+	return password, err
+}
+
+// AcceptTermsOfService is only asked for numbers without an account.
+func (a *Authenticator) AcceptTermsOfService(ctx context.Context, tos tg.HelpTermsOfService) error {
+	return &auth.SignUpRequired{TermsOfService: tos}
+}
+
+func (a *Authenticator) SignUp(ctx context.Context) (auth.UserInfo, error) {
+	return auth.UserInfo{}, errors.New("this phone number has no Telegram account, sign up in a Telegram app first")
+}
+
+func codeDestination(sentCode *tg.AuthSentCode) string {
+	if sentCode == nil {
+		return "to you"
+	}
+	switch sentCode.Type.(type) {
+	case *tg.AuthSentCodeTypeApp:
+		return "to your Telegram app"
+	case *tg.AuthSentCodeTypeSMS:
+		return "by SMS"
+	case *tg.AuthSentCodeTypeCall:
+		return "by a phone call"
+	case *tg.AuthSentCodeTypeEmailCode:
+		return "to your email"
+	default:
+		return "to you"
+	}
+}
+
+// Login signs client in with the phone number, the login code and the 2FA
+// password answered by a.
+func Login(ctx context.Context, client auth.FlowClient, a auth.UserAuthenticator) error {
+	return auth.NewFlow(a, auth.SendCodeOptions{}).Run(ctx, client)
+}
+
+// GeneratePhoneSession logs in with a phone number and prints the string
+// session in format. Without interactive, nothing but the session string is
+// written to stdout.
+func GeneratePhoneSession(apiId int, apiHash string, format sessionstring.Format, interactive bool) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	status := io.Writer(os.Stdout)
+	if !interactive {
+		status = os.Stderr
+	}
+	fmt.Fprintln(status, "Generating phone session...")
+	sessionStorage := &session.StorageMemory{}
+	client := telegram.NewClient(apiId, apiHash, telegram.Options{
+		SessionStorage: sessionStorage,
+		Device: telegram.DeviceConfig{
+			DeviceModel:   "Pyrogram",
+			SystemVersion: runtime.GOOS,
+			AppVersion:    "2.0",
+		},
+	})
+	authenticator := &Authenticator{In: os.Stdin, Out: os.Stdout, Interactive: interactive}
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		authenticator.ReadPassword = func() ([]byte, error) { return term.ReadPassword(fd) }
+	}
+	return client.Run(ctx, func(ctx context.Context) error {
+		if err := Login(ctx, client.Auth(), authenticator); err != nil {
+			return fmt.Errorf("failed to log in: %w", err)
+		}
+		user, err := client.Self(ctx)
+		if err != nil {
+			return err
+		}
+		if user.Username == "" {
+			fmt.Fprintln(status, "Logged in as", user.FirstName, user.LastName)
+		} else {
+			fmt.Fprintln(status, "Logged in as @"+user.Username)
+		}
+		stringSession, err := sessionstring.EncodeStorage(ctx, sessionStorage, format, sessionstring.AccountOf(apiId, user))
+		if err != nil {
+			return err
+		}
+		if !interactive {
+			fmt.Println(stringSession)
+			return nil
+		}
+		fmt.Printf("Your %s session string: %s\n", format, stringSession)
+		client.API().MessagesSendMessage(
+			ctx,
+			&tg.MessagesSendMessageRequest{
+				NoWebpage: true,
+				Peer:      &tg.InputPeerSelf{},
+				Message:   fmt.Sprintf("Your %s session string: %s", format, stringSession),
+			},
+		)
+		return nil
+	})
+}
@@ -0,0 +1,176 @@
+package phonelogin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/gotd/td/telegram/auth"
+	"github.com/gotd/td/tg"
+)
+
+// fakeBackend is an auth.FlowClient accepting one phone number, code and
+// 2FA password.
+type fakeBackend struct {
+	phone, code, password string
+
+	sentTo   string
+	loggedIn bool
+}
+
+func (f *fakeBackend) SendCode(ctx context.Context, phone string, options auth.SendCodeOptions) (tg.AuthSentCodeClass, error) {
+	if phone != f.phone {
+		return nil, errors.New("PHONE_NUMBER_INVALID")
+	}
+	f.sentTo = phone
+	return &tg.AuthSentCode{Type: &tg.AuthSentCodeTypeApp{Length: 5}, PhoneCodeHash: "hash"}, nil
+}
+
+func (f *fakeBackend) SignIn(ctx context.Context, phone, code, codeHash string) (*tg.AuthAuthorization, error) {
+	if phone != f.sentTo || codeHash != "hash" || code != f.code {
+		return nil, errors.New("PHONE_CODE_INVALID")
+	}
+	if f.password != "" {
+		return nil, auth.ErrPasswordAuthNeeded
+	}
+	f.loggedIn = true
+	return &tg.AuthAuthorization{}, nil
+}
+
+func (f *fakeBackend) Password(ctx context.Context, password string) (*tg.AuthAuthorization, error) {
+	if password != f.password {
+		return nil, errors.New("PASSWORD_HASH_INVALID")
+	}
+	f.loggedIn = true
+	return &tg.AuthAuthorization{}, nil
+}
+
+func (f *fakeBackend) SignUp(ctx context.Context, s auth.SignUp) (*tg.AuthAuthorization, error) {
+	return nil, errors.New("unexpected sign up")
+}
+
+func noEnv(string) (string, bool) { return "", false }
+
+func TestLoginInteractive(t *testing.T) {
+	backend := &fakeBackend{phone: "+14155552671", code: "12345", password: "hunter2"}
+	out := new(bytes.Buffer)
+	a := &Authenticator{
+		In:          strings.NewReader("+14155552671\n 12345 \nhunter2\n"),
+		Out:         out,
+		Interactive: true,
+		LookupEnv:   noEnv,
+	}
+	if err := Login(context.Background(), backend, a); err != nil {
+		t.Fatal(err)
+	}
+	if !backend.loggedIn {
+		t.Error("expected to be logged in")
+	}
+	for _, prompt := range []string{"phone number", "sent to your Telegram app", "2FA password"} {
+		if !strings.Contains(out.String(), prompt) {
+			t.Errorf("expected a prompt with %q in %q", prompt, out.String())
+		}
+	}
+}
+
+func TestLoginNonInteractive(t *testing.T) {
+	backend := &fakeBackend{phone: "+14155552671", code: "12345", password: "hunter2"}
+	env := map[string]string{PhoneEnv: "+14155552671", PasswordEnv: "hunter2"}
+	out := new(bytes.Buffer)
+	a := &Authenticator{
+		// Only the code isn't known in advance
+		In:        strings.NewReader("12345"),
+		Out:       out,
+		LookupEnv: func(key string) (string, bool) { v, ok := env[key]; return v, ok },
+	}
+	if err := Login(context.Background(), backend, a); err != nil {
+		t.Fatal(err)
+	}
+	if !backend.loggedIn {
+		t.Error("expected to be logged in")
+	}
+	if out.Len() != 0 {
+		t.Errorf("expected no prompts, got %q", out.String())
+	}
+}
+
+func TestLoginErrors(t *testing.T) {
+	tests := []struct {
+		name, input, want string
+	}{
+		{"wrong code", "+14155552671\n54321\n", "PHONE_CODE_INVALID"},
+		{"wrong password", "+14155552671\n12345\nhunter3\n", "PASSWORD_HASH_INVALID"},
+		{"no code", "+14155552671\n", CodeEnv},
+		{"no password", "+14155552671\n12345\n", auth.ErrPasswordNotProvided.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &fakeBackend{phone: "+14155552671", code: "12345", password: "hunter2"}
+			a := &Authenticator{In: strings.NewReader(tt.input), Out: new(bytes.Buffer), LookupEnv: noEnv}
+			err := Login(context.Background(), backend, a)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("expected an error with %q, got %v", tt.want, err)
+			}
+			if backend.loggedIn {
+				t.Error("expected not to be logged in")
+			}
+		})
+	}
+}
+
+func TestLoginHiddenPassword(t *testing.T) {
+	backend := &fakeBackend{phone: "+14155552671", code: "12345", password: "hunter2"}
+	out := new(bytes.Buffer)
+	a := &Authenticator{
+		// the password must not be read from In
+		In:           strings.NewReader("+14155552671\n12345\nwrong\n"),
+		Out:          out,
+		Interactive:  true,
+		LookupEnv:    noEnv,
+		ReadPassword: func() ([]byte, error) { return []byte("hunter2"), nil },
+	}
+	if err := Login(context.Background(), backend, a); err != nil {
+		t.Fatal(err)
+	}
+	if !backend.loggedIn {
+		t.Error("expected to be logged in")
+	}
+	if strings.Contains(out.String(), "hunter2") {
+		t.Errorf("password was echoed: %q", out.String())
+	}
+}
+
+func TestPasswordErrors(t *testing.T) {
+	readErr := errors.New("terminal gone")
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name         string
+		ctx          context.Context
+		input        string
+		readPassword func() ([]byte, error)
+		want         error
+	}{
+		{name: "no answer", ctx: context.Background(), input: "\n", want: auth.ErrPasswordNotProvided},
+		{name: "end of input", ctx: context.Background(), want: auth.ErrPasswordNotProvided},
+		{name: "empty hidden answer", ctx: context.Background(), readPassword: func() ([]byte, error) { return nil, nil }, want: auth.ErrPasswordNotProvided},
+		{name: "cancelled", ctx: cancelled, input: "hunter2\n", want: context.Canceled},
+		{name: "read error", ctx: context.Background(), readPassword: func() ([]byte, error) { return nil, readErr }, want: readErr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &Authenticator{
+				In:           strings.NewReader(tt.input),
+				Out:          new(bytes.Buffer),
+				LookupEnv:    noEnv,
+				ReadPassword: tt.readPassword,
+			}
+			_, err := a.Password(tt.ctx)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -15,6 +15,9 @@ import (
 	"github.com/gotd/td/session"
 )
 
+// EncodeToPyrogramSession stores the auth key ID in place of the user ID.
+//
+// Deprecated: use sessionstring.Encode, which stores the user ID.
 func EncodeToPyrogramSession(data *session.Data, appID int32) (string, error) {
 	buf := new(bytes.Buffer)
 	if err := buf.WriteByte(byte(data.DC)); err != nil {
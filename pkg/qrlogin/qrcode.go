@@ -8,7 +8,6 @@ package qrlogin
 import (
 	"bufio"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
@@ -16,6 +15,8 @@ import (
 	"strings"
 	"time"
 
+	"EverythingSuckz/fsb/pkg/sessionstring"
+
 	"github.com/gotd/td/session"
 	"github.com/gotd/td/telegram"
 	"github.com/gotd/td/telegram/auth/qrlogin"
@@ -48,7 +49,9 @@ func clearQrCode(writer *CustomWriter) {
 	writer.LineLength = 0
 }
 
-func GenerateQRSession(apiId int, apiHash string) error {
+// GenerateQRSession logs in by scanning a QR code and prints the string
+// session in format.
+func GenerateQRSession(apiId int, apiHash string, format sessionstring.Format) error {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	fmt.Println("Generating QR session...")
@@ -123,24 +126,17 @@ func GenerateQRSession(apiId int, apiHash string) error {
 		} else {
 			fmt.Println("Logged in as @", user.Username)
 		}
-		res, _ := sessionStorage.LoadSession(ctx)
-		type jsonDataStruct struct {
-			Version int
-			Data    session.Data
-		}
-		var jsonData jsonDataStruct
-		json.Unmarshal(res, &jsonData)
-		stringSession, err = EncodeToPyrogramSession(&jsonData.Data, int32(apiId))
+		stringSession, err = sessionstring.EncodeStorage(ctx, sessionStorage, format, sessionstring.AccountOf(apiId, user))
 		if err != nil {
 			return err
 		}
-		fmt.Println("Your pyrogram session string:", stringSession)
+		fmt.Printf("Your %s session string: %s\n", format, stringSession)
 		client.API().MessagesSendMessage(
 			ctx,
 			&tg.MessagesSendMessageRequest{
 				NoWebpage: true,
 				Peer:      &tg.InputPeerSelf{},
-				Message:   "Your pyrogram session string: " + stringSession,
+				Message:   fmt.Sprintf("Your %s session string: %s", format, stringSession),
 			},
 		)
 		return nil
@@ -0,0 +1,350 @@
+// This file is a part of EverythingSuckz/TG-FileStreamBot
+// And is licenced under the Affero General Public License.
+// Any distributions of this code MUST be accompanied by a copy of the AGPL
+// with proper attribution to the original author(s).
+
+// Package sessionstring encodes and decodes the string sessions of
+// Pyrogram, Telethon, gotd and gotgproto.
+package sessionstring
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/celestix/gotgproto/functions"
+	"github.com/celestix/gotgproto/storage"
+	"github.com/gotd/td/crypto"
+	"github.com/gotd/td/session"
+	"github.com/gotd/td/telegram/dcs"
+	"github.com/gotd/td/tg"
+)
+
+// Format is the library a string session is written for.
+type Format string
+
+const (
+	Pyrogram  Format = "pyrogram"
+	Telethon  Format = "telethon"
+	Gotd      Format = "gotd"
+	Gotgproto Format = "gotgproto"
+)
+
+// Formats lists the supported formats.
+var Formats = []Format{Pyrogram, Telethon, Gotd, Gotgproto}
+
+// ParseFormat returns the format named name.
+func ParseFormat(name string) (Format, error) {
+	for _, format := range Formats {
+		if strings.EqualFold(name, string(format)) {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("unknown session format %q, expected one of %s", name, formatList())
+}
+
+func formatList() string {
+	names := make([]string, len(Formats))
+	for i, format := range Formats {
+		names[i] = string(format)
+	}
+	return strings.Join(names, ", ")
+}
+
+// Account is what Pyrogram sessions store about the account besides the
+// auth key. The other formats don't store it.
+type Account struct {
+	AppID  int32
+	UserID int64
+	IsBot  bool
+}
+
+// Session is a decoded string session.
+type Session struct {
+	Format Format
+	Data   *session.Data
+	// Account is only known for Pyrogram sessions, UserID is 0 otherwise.
+	Account Account
+}
+
+// Sizes of the fields of the string sessions.
+const (
+	authKeySize = 256
+	// DC, app ID, test mode, auth key, user ID, is bot
+	pyrogramSize = 1 + 4 + 1 + authKeySize + 8 + 1
+	// Pyrogram sessions before 2.0 don't store the app ID, the oldest ones
+	// store a 32 bit user ID
+	pyrogramOldSize   = pyrogramSize - 4
+	pyrogramOld32Size = pyrogramOldSize - 4
+	// DC, IPv4 or IPv6, port, auth key
+	telethonSizeV4 = 1 + 4 + 2 + authKeySize
+	telethonSizeV6 = 1 + 16 + 2 + authKeySize
+
+	telethonVersion = '1'
+	gotdVersion     = 1
+)
+
+// gotdSession is how gotd stores sessions and what gotgproto stores as
+// session data.
+type gotdSession struct {
+	Version int
+	Data    session.Data
+}
+
+// Decode detects the format of a string session, decodes it and checks that
+// it is well-formed.
+func Decode(value string) (*Session, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, errors.New("session string is empty")
+	}
+	var s *Session
+	var err error
+	switch {
+	case value[0] == telethonVersion:
+		s, err = decodeTelethon(value)
+	case strings.HasPrefix(value, "eyJ"):
+		// base64 of the JSON object of gotd and gotgproto sessions
+		s, err = decodeJSON(value)
+	default:
+		s, err = decodePyrogram(value)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.check(); err != nil {
+		return nil, fmt.Errorf("invalid %s session: %w", s.Format, err)
+	}
+	return s, nil
+}
+
+func decodePyrogram(value string) (*Session, error) {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+	if err != nil {
+		return nil, fmt.Errorf("not a pyrogram, telethon, gotd or gotgproto session: %w", err)
+	}
+	var account Account
+	switch len(data) {
+	case pyrogramSize:
+		account.AppID = int32(binary.BigEndian.Uint32(data[1:5]))
+		data = append(data[:1:1], data[5:]...)
+	case pyrogramOldSize, pyrogramOld32Size:
+	default:
+		return nil, fmt.Errorf("not a pyrogram, telethon, gotd or gotgproto session: decodes to %d bytes, pyrogram sessions have %d", len(data), pyrogramSize)
+	}
+	// DC, test mode, auth key, user ID, is bot
+	key := data[2 : 2+authKeySize]
+	id := authKeyID(key)
+	userID := data[2+authKeySize : len(data)-1]
+	switch {
+	case len(userID) == 4:
+		account.UserID = int64(binary.BigEndian.Uint32(userID))
+	case !bytes.Equal(userID, id):
+		// Sessions written by older versions of fsb store the auth key ID
+		// in place of the user ID
+		account.UserID = int64(binary.BigEndian.Uint64(userID))
+	}
+	account.IsBot = data[len(data)-1] == 1
+	return &Session{
+		Format: Pyrogram,
+		Data: &session.Data{
+			DC:        int(data[0]),
+			AuthKey:   key,
+			AuthKeyID: id,
+			Config:    session.Config{TestMode: data[1] == 1},
+		},
+		Account: account,
+	}, nil
+}
+
+func decodeTelethon(value string) (*Session, error) {
+	data, err := base64.URLEncoding.DecodeString(value[1:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid telethon session: %w", err)
+	}
+	var ipSize int
+	switch len(data) {
+	case telethonSizeV4:
+		ipSize = 4
+	case telethonSizeV6:
+		ipSize = 16
+	default:
+		return nil, fmt.Errorf("invalid telethon session: %d bytes, expected %d or %d", len(data), telethonSizeV4, telethonSizeV6)
+	}
+	ip := net.IP(data[1 : 1+ipSize])
+	port := binary.BigEndian.Uint16(data[1+ipSize : 3+ipSize])
+	key := data[3+ipSize:]
+	return &Session{
+		Format: Telethon,
+		Data: &session.Data{
+			DC:        int(data[0]),
+			Addr:      net.JoinHostPort(ip.String(), strconv.Itoa(int(port))),
+			AuthKey:   key,
+			AuthKeyID: authKeyID(key),
+		},
+	}, nil
+}
+
+func decodeJSON(value string) (*Session, error) {
+	raw, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gotd or gotgproto session: %w", err)
+	}
+	var envelope struct {
+		Version int
+		Data    json.RawMessage
+	}
+	if err := json.Unmarshal(raw, &envelope); err != nil {
+		return nil, fmt.Errorf("invalid gotd or gotgproto session: %w", err)
+	}
+	format := Gotd
+	if bytes.HasPrefix(envelope.Data, []byte(`"`)) {
+		// gotgproto stores the gotd session as bytes
+		format = Gotgproto
+		if err := json.Unmarshal(envelope.Data, &raw); err != nil {
+			return nil, fmt.Errorf("invalid gotgproto session: %w", err)
+		}
+	}
+	var s gotdSession
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, fmt.Errorf("invalid %s session: %w", format, err)
+	}
+	if s.Version != gotdVersion {
+		return nil, fmt.Errorf("invalid %s session: unsupported version %d", format, s.Version)
+	}
+	return &Session{Format: format, Data: &s.Data}, nil
+}
+
+// check reports sessions that can't log in.
+func (s *Session) check() error {
+	data := s.Data
+	if data.DC < 1 || data.DC > 5 {
+		return fmt.Errorf("invalid DC %d", data.DC)
+	}
+	if len(data.AuthKey) != authKeySize {
+		return fmt.Errorf("auth key is %d bytes, expected %d", len(data.AuthKey), authKeySize)
+	}
+	if bytes.Equal(data.AuthKey, make([]byte, authKeySize)) {
+		return errors.New("auth key is empty")
+	}
+	if !bytes.Equal(data.AuthKeyID, authKeyID(data.AuthKey)) {
+		return errors.New("auth key ID doesn't match the auth key")
+	}
+	return nil
+}
+
+func authKeyID(key []byte) []byte {
+	var k crypto.Key
+	copy(k[:], key)
+	id := k.ID()
+	return id[:]
+}
+
+// Encode writes data as a string session of format. account is only
+// stored by Pyrogram sessions.
+func Encode(format Format, data *session.Data, account Account) (string, error) {
+	if err := (&Session{Format: format, Data: data}).check(); err != nil {
+		return "", err
+	}
+	switch format {
+	case Pyrogram:
+		return encodePyrogram(data, account), nil
+	case Telethon:
+		return encodeTelethon(data)
+	case Gotd:
+		raw, err := json.Marshal(gotdSession{Version: gotdVersion, Data: *data})
+		if err != nil {
+			return "", err
+		}
+		return base64.StdEncoding.EncodeToString(raw), nil
+	case Gotgproto:
+		raw, err := json.Marshal(gotdSession{Version: gotdVersion, Data: *data})
+		if err != nil {
+			return "", err
+		}
+		return functions.EncodeSessionToString(&storage.Session{Version: storage.LatestVersion, Data: raw})
+	default:
+		return "", fmt.Errorf("unknown session format %q, expected one of %s", format, formatList())
+	}
+}
+
+func encodePyrogram(data *session.Data, account Account) string {
+	buf := new(bytes.Buffer)
+	buf.WriteByte(byte(data.DC))
+	binary.Write(buf, binary.BigEndian, account.AppID)
+	buf.WriteByte(boolByte(data.Config.TestMode))
+	buf.Write(data.AuthKey)
+	binary.Write(buf, binary.BigEndian, account.UserID)
+	buf.WriteByte(boolByte(account.IsBot))
+	return base64.RawURLEncoding.EncodeToString(buf.Bytes())
+}
+
+func encodeTelethon(data *session.Data) (string, error) {
+	host, port, err := dcAddr(data)
+	if err != nil {
+		return "", err
+	}
+	ip := net.ParseIP(host)
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	buf := new(bytes.Buffer)
+	buf.WriteByte(byte(data.DC))
+	buf.Write(ip)
+	binary.Write(buf, binary.BigEndian, uint16(port))
+	buf.Write(data.AuthKey)
+	return string(telethonVersion) + base64.URLEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// dcAddr returns the IP and port of the DC of the session, which Telethon
+// sessions store.
+func dcAddr(data *session.Data) (string, int, error) {
+	if data.Addr != "" {
+		host, port, err := net.SplitHostPort(data.Addr)
+		if err == nil && net.ParseIP(host) != nil {
+			p, err := strconv.Atoi(port)
+			return host, p, err
+		}
+	}
+	options := data.Config.DCOptions
+	if len(options) == 0 {
+		options = dcs.Prod().Options
+		if data.Config.TestMode {
+			options = dcs.Test().Options
+		}
+	}
+	for _, option := range dcs.FindPrimaryDCs(options, data.DC, false) {
+		if ip := net.ParseIP(option.IPAddress); ip != nil {
+			return option.IPAddress, option.Port, nil
+		}
+	}
+	return "", 0, fmt.Errorf("no address known for DC %d", data.DC)
+}
+
+func boolByte(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// EncodeStorage encodes the session a client logged in with.
+func EncodeStorage(ctx context.Context, s *session.StorageMemory, format Format, account Account) (string, error) {
+	data, err := (&session.Loader{Storage: s}).Load(ctx)
+	if err != nil {
+		return "", err
+	}
+	return Encode(format, data, account)
+}
+
+// AccountOf returns the Account of a logged in user.
+func AccountOf(appID int, user *tg.User) Account {
+	return Account{AppID: int32(appID), UserID: user.ID, IsBot: user.Bot}
+}
@@ -0,0 +1,143 @@
+package sessionstring
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/celestix/gotgproto/functions"
+	"github.com/celestix/gotgproto/sessionMaker"
+	"github.com/gotd/td/session"
+)
+
+func testData() *session.Data {
+	key := make([]byte, authKeySize)
+	for i := range key {
+		key[i] = byte(i*7 + 1)
+	}
+	return &session.Data{DC: 4, AuthKey: key, AuthKeyID: authKeyID(key)}
+}
+
+func TestRoundTrip(t *testing.T) {
+	data := testData()
+	account := Account{AppID: 12345, UserID: 777000, IsBot: false}
+	for _, format := range Formats {
+		t.Run(string(format), func(t *testing.T) {
+			value, err := Encode(format, data, account)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s, err := Decode(value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.Format != format {
+				t.Errorf("detected %s", s.Format)
+			}
+			if s.Data.DC != data.DC || !bytes.Equal(s.Data.AuthKey, data.AuthKey) || !bytes.Equal(s.Data.AuthKeyID, data.AuthKeyID) {
+				t.Errorf("session changed: DC %d", s.Data.DC)
+			}
+			if format == Pyrogram && s.Account != account {
+				t.Errorf("account changed: %+v", s.Account)
+			}
+			if format == Telethon && s.Data.Addr != "149.154.167.91:443" {
+				t.Errorf("unexpected address %s", s.Data.Addr)
+			}
+		})
+	}
+}
+
+// The encoded sessions must be readable by the libraries they are for.
+func TestLibraryCompatibility(t *testing.T) {
+	data := testData()
+	pyrogram, _ := Encode(Pyrogram, data, Account{AppID: 1, UserID: 2})
+	decoded, err := sessionMaker.DecodePyrogramSession(pyrogram)
+	if err != nil || !bytes.Equal(decoded.AuthKey, data.AuthKey) || decoded.DC != data.DC {
+		t.Errorf("gotgproto can't read the pyrogram session: %v", err)
+	}
+
+	telethon, _ := Encode(Telethon, data, Account{})
+	decoded, err = session.TelethonSession(telethon)
+	if err != nil || !bytes.Equal(decoded.AuthKey, data.AuthKey) || decoded.DC != data.DC {
+		t.Errorf("gotd can't read the telethon session: %v", err)
+	}
+
+	gotgproto, _ := Encode(Gotgproto, data, Account{})
+	stored, err := functions.DecodeStringToSession(gotgproto)
+	if err != nil {
+		t.Fatalf("gotgproto can't read its session: %v", err)
+	}
+	storage := new(session.StorageMemory)
+	storage.StoreSession(context.Background(), stored.Data)
+	loaded, err := (&session.Loader{Storage: storage}).Load(context.Background())
+	if err != nil || !bytes.Equal(loaded.AuthKey, data.AuthKey) {
+		t.Errorf("gotd can't load the gotgproto session: %v", err)
+	}
+}
+
+// Older versions stored the auth key ID in place of the user ID.
+func TestDecodeOldPyrogram(t *testing.T) {
+	data := testData()
+	value, err := Encode(Pyrogram, data, Account{AppID: 12345, UserID: int64(binary.BigEndian.Uint64(data.AuthKeyID))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := Decode(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Format != Pyrogram || s.Account.AppID != 12345 || s.Account.UserID != 0 {
+		t.Errorf("unexpected session %s %+v", s.Format, s.Account)
+	}
+}
+
+func TestDecodePyrogram1(t *testing.T) {
+	data := testData()
+	// DC, test mode, auth key, 32 bit user ID, is bot
+	raw := append([]byte{byte(data.DC), 0}, data.AuthKey...)
+	raw = binary.BigEndian.AppendUint32(raw, 4242)
+	raw = append(raw, 1)
+	s, err := Decode(base64.URLEncoding.EncodeToString(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Account.UserID != 4242 || !s.Account.IsBot || s.Data.DC != data.DC {
+		t.Errorf("unexpected session %+v", s.Account)
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	data := testData()
+	pyrogram, _ := Encode(Pyrogram, data, Account{})
+	gotd, _ := Encode(Gotd, data, Account{})
+	raw, _ := base64.StdEncoding.DecodeString(gotd)
+	var s gotdSession
+	json.Unmarshal(raw, &s)
+	s.Data.AuthKeyID = []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	raw, _ = json.Marshal(s)
+	badID := base64.StdEncoding.EncodeToString(raw)
+
+	tests := map[string]struct {
+		value, want string
+	}{
+		"empty":     {"", "empty"},
+		"garbage":   {"not a session!", "not a pyrogram"},
+		"truncated": {pyrogram[:100], "bytes"},
+		"telethon":  {"1AAAA", "telethon"},
+		"key id":    {badID, "auth key ID"},
+		"zero key":  {base64.RawURLEncoding.EncodeToString(append([]byte{2, 0, 0, 0, 1, 0}, make([]byte, 265)...)), "empty"},
+		"bad dc":    {base64.RawURLEncoding.EncodeToString(append([]byte{9, 0, 0, 0, 1, 0}, bytes.Repeat([]byte{1}, 265)...)), "DC"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Decode(tt.value)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected an error with %q, got %v", tt.want, err)
+			}
+		})
+	}
+}